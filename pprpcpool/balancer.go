@@ -0,0 +1,184 @@
+package pprpcpool
+
+// 负载均衡策略
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	LBRoundRobin         = "round_robin"
+	LBWeightedRoundRobin = "weighted_round_robin"
+	LBRandom             = "random"
+	LBLeastInflight      = "least_inflight"
+	LBP2C                = "p2c"
+	LBLoadAware          = "load_aware"
+)
+
+// Balancer pick one host from the connected hosts of the pool.
+// Implementations must be safe for concurrent use.
+type Balancer interface {
+	Pick(hosts []*ClientConnInfo) (*ClientConnInfo, error)
+}
+
+// NewBalancer create balancer by name
+func NewBalancer(name string) (lb Balancer, err error) {
+	switch name {
+	case LBRoundRobin, "":
+		lb = NewRoundRobinBalancer()
+	case LBWeightedRoundRobin:
+		lb = NewWeightedRoundRobinBalancer()
+	case LBRandom:
+		lb = NewRandomBalancer()
+	case LBLeastInflight:
+		lb = NewLeastInflightBalancer()
+	case LBP2C:
+		lb = NewP2CBalancer()
+	case LBLoadAware:
+		lb = NewLoadAwareBalancer()
+	default:
+		err = fmt.Errorf("unknown balancer: %s", name)
+	}
+	return
+}
+
+// RoundRobinBalancer round-robin
+type RoundRobinBalancer struct {
+	next uint32
+}
+
+// NewRoundRobinBalancer create round-robin balancer
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return new(RoundRobinBalancer)
+}
+
+// Pick .
+func (b *RoundRobinBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	v := atomic.AddUint32(&b.next, 1)
+	info = hosts[(v-1)%uint32(len(hosts))]
+	return
+}
+
+// WeightedRoundRobinBalancer smooth weighted round-robin (nginx)
+type WeightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[string]int
+}
+
+// NewWeightedRoundRobinBalancer create weighted round-robin balancer
+func NewWeightedRoundRobinBalancer() *WeightedRoundRobinBalancer {
+	_t := new(WeightedRoundRobinBalancer)
+	_t.current = make(map[string]int)
+	return _t
+}
+
+// Pick .
+func (b *WeightedRoundRobinBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// drop removed hosts
+	if len(b.current) > 2*len(hosts) {
+		b.current = make(map[string]int)
+	}
+	total := 0
+	for _, h := range hosts {
+		w := h.Weight()
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		b.current[h.urladdr] += w
+		if info == nil || b.current[h.urladdr] > b.current[info.urladdr] {
+			info = h
+		}
+	}
+	b.current[info.urladdr] -= total
+	return
+}
+
+// RandomBalancer random
+type RandomBalancer struct{}
+
+// NewRandomBalancer create random balancer
+func NewRandomBalancer() *RandomBalancer {
+	return new(RandomBalancer)
+}
+
+// Pick .
+func (b *RandomBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	info = hosts[rand.Intn(len(hosts))]
+	return
+}
+
+// LeastInflightBalancer pick the host with the fewest requests in flight
+type LeastInflightBalancer struct {
+	next uint32
+}
+
+// NewLeastInflightBalancer create least in-flight balancer
+func NewLeastInflightBalancer() *LeastInflightBalancer {
+	return new(LeastInflightBalancer)
+}
+
+// Pick .
+func (b *LeastInflightBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	// rotate the start, ties do not always go to the first host
+	start := int(atomic.AddUint32(&b.next, 1) % uint32(len(hosts)))
+	for i := 0; i < len(hosts); i++ {
+		h := hosts[(start+i)%len(hosts)]
+		if info == nil || h.Inflight() < info.Inflight() {
+			info = h
+		}
+	}
+	return
+}
+
+// P2CBalancer power of two choices, pick the less loaded of two random hosts
+type P2CBalancer struct{}
+
+// NewP2CBalancer create power of two choices balancer
+func NewP2CBalancer() *P2CBalancer {
+	return new(P2CBalancer)
+}
+
+// Pick .
+func (b *P2CBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	if len(hosts) == 1 {
+		info = hosts[0]
+		return
+	}
+	i := rand.Intn(len(hosts))
+	j := rand.Intn(len(hosts) - 1)
+	if j >= i {
+		j++
+	}
+	info = hosts[i]
+	if hosts[j].Inflight() < info.Inflight() {
+		info = hosts[j]
+	}
+	return
+}
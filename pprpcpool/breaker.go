@@ -0,0 +1,246 @@
+package pprpcpool
+
+// 熔断
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// circuit breaker state
+const (
+	BreakerClosed   int32 = 0
+	BreakerOpen     int32 = 1
+	BreakerHalfOpen int32 = 2
+)
+
+// BreakerConf circuit breaker config
+type BreakerConf struct {
+	Window           time.Duration // rolling window, default 10s
+	Buckets          int           // window buckets, default 10
+	MinRequests      int           // min requests in window to trip, default 20
+	ErrorPercent     int           // trip when error percent >= it, default 50
+	OpenTimeout      time.Duration // open to half-open, default 5s
+	HalfOpenRequests int           // probes in half-open, all succeed to close, default 1
+}
+
+// CircuitOpenError call rejected by an open circuit breaker
+type CircuitOpenError struct {
+	Name string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open: %s", e.Name)
+}
+
+// IsCircuitOpen .
+func IsCircuitOpen(err error) bool {
+	var e *CircuitOpenError
+	return errors.As(err, &e)
+}
+
+type breakerBucket struct {
+	idx   int64
+	total int
+	fails int
+}
+
+// CircuitBreaker closed, open, half-open circuit breaker over a rolling window
+type CircuitBreaker struct {
+	name string
+	conf BreakerConf
+
+	// state, openedAt, probes written under mu, read by ready without it
+	mu       sync.Mutex
+	state    int32
+	openedAt int64 // unix nano
+	buckets  []breakerBucket
+	probes   int32 // half-open in flight
+	success  int   // half-open succeeded
+}
+
+// NewCircuitBreaker create circuit breaker
+func NewCircuitBreaker(name string, conf BreakerConf) *CircuitBreaker {
+	if conf.Window <= 0 {
+		conf.Window = 10 * time.Second
+	}
+	if conf.Buckets <= 0 {
+		conf.Buckets = 10
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = 20
+	}
+	if conf.ErrorPercent <= 0 || conf.ErrorPercent > 100 {
+		conf.ErrorPercent = 50
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 5 * time.Second
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 1
+	}
+	b := new(CircuitBreaker)
+	b.name = name
+	b.conf = conf
+	b.buckets = make([]breakerBucket, conf.Buckets)
+	return b
+}
+
+// State .
+func (b *CircuitBreaker) State() int32 {
+	return atomic.LoadInt32(&b.state)
+}
+
+// ready false if Allow would reject, lock free.
+func (b *CircuitBreaker) ready() bool {
+	switch atomic.LoadInt32(&b.state) {
+	case BreakerOpen:
+		return b.openTimeout()
+	case BreakerHalfOpen:
+		return atomic.LoadInt32(&b.probes) < int32(b.conf.HalfOpenRequests)
+	}
+	return true
+}
+
+// openTimeout the open state timed out
+func (b *CircuitBreaker) openTimeout() bool {
+	return time.Now().UnixNano()-atomic.LoadInt64(&b.openedAt) >= int64(b.conf.OpenTimeout)
+}
+
+// Allow return *CircuitOpenError if the call is rejected, or Done must be called.
+func (b *CircuitBreaker) Allow() (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.openTimeout() == false {
+			err = &CircuitOpenError{Name: b.name}
+			return
+		}
+		atomic.StoreInt32(&b.probes, 0)
+		atomic.StoreInt32(&b.state, BreakerHalfOpen)
+		b.success = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= int32(b.conf.HalfOpenRequests) {
+			err = &CircuitOpenError{Name: b.name}
+			return
+		}
+		atomic.AddInt32(&b.probes, 1)
+	}
+	return
+}
+
+// Done record the result of an allowed call.
+func (b *CircuitBreaker) Done(err error) {
+	// canceled by the caller or shed by the client limit, not a failure
+	fail := err != nil && errors.Is(err, context.Canceled) == false && IsOverload(err) == false
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		bk := b.bucket(time.Now())
+		bk.total++
+		if fail {
+			bk.fails++
+		}
+		total, fails := b.sum(time.Now())
+		if total >= b.conf.MinRequests && fails*100 >= total*b.conf.ErrorPercent {
+			b.trip()
+		}
+	case BreakerHalfOpen:
+		if b.probes > 0 {
+			atomic.AddInt32(&b.probes, -1)
+		}
+		if fail {
+			b.trip()
+			return
+		}
+		b.success++
+		if b.success >= b.conf.HalfOpenRequests {
+			atomic.StoreInt32(&b.state, BreakerClosed)
+			b.reset()
+		}
+	}
+}
+
+func (b *CircuitBreaker) trip() {
+	atomic.StoreInt64(&b.openedAt, time.Now().UnixNano())
+	atomic.StoreInt32(&b.state, BreakerOpen)
+	b.reset()
+}
+
+func (b *CircuitBreaker) reset() {
+	for i := range b.buckets {
+		b.buckets[i] = breakerBucket{}
+	}
+}
+
+func (b *CircuitBreaker) bucketIdx(now time.Time) int64 {
+	return now.UnixNano() / int64(b.conf.Window/time.Duration(len(b.buckets)))
+}
+
+func (b *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	idx := b.bucketIdx(now)
+	bk := &b.buckets[idx%int64(len(b.buckets))]
+	if bk.idx != idx {
+		*bk = breakerBucket{idx: idx}
+	}
+	return bk
+}
+
+func (b *CircuitBreaker) sum(now time.Time) (total, fails int) {
+	idx := b.bucketIdx(now)
+	for _, bk := range b.buckets {
+		if idx-bk.idx < int64(len(b.buckets)) {
+			total += bk.total
+			fails += bk.fails
+		}
+	}
+	return
+}
+
+// SetBreaker enable circuit breaker per host.
+func (r *RPCCliPool) SetBreaker(conf BreakerConf) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.breakerConf = &conf
+	for _, h := range r.allHosts() {
+		h.breaker.Store(NewCircuitBreaker(h.urladdr, conf))
+	}
+}
+
+func (c *ClientConnInfo) getBreaker() *CircuitBreaker {
+	b, _ := c.breaker.Load().(*CircuitBreaker)
+	return b
+}
+
+// SetBreaker enable circuit breaker of the micro service.
+func (m *MicroClientConn) SetBreaker(ms string, conf BreakerConf) (err error) {
+	b := NewCircuitBreaker(ms, conf)
+	return m.updateMicro(ms, func(cp *ClientPool) {
+		cp.breaker = b
+	})
+}
+
+func (c *ClientPool) allow() error {
+	if c.breaker == nil {
+		return nil
+	}
+	return c.breaker.Allow()
+}
+
+func (c *ClientPool) done(err error) {
+	if c.breaker == nil {
+		return
+	}
+	c.breaker.Done(err)
+}
@@ -0,0 +1,142 @@
+package pprpcpool
+
+// 广播/并发调用所有节点
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pprpc/core/packets"
+)
+
+// DefaultFanOutConcurrency calls in flight of InvokeAll/Broadcast
+const DefaultFanOutConcurrency = 16
+
+// FanOutOpts InvokeAll/Broadcast options
+type FanOutOpts struct {
+	Concurrency int // calls in flight, default DefaultFanOutConcurrency
+	Quorum      int // return once Quorum hosts succeed and cancel the rest, results hold the finished ones; 0: wait all
+}
+
+// HostResult result of one host
+type HostResult struct {
+	Host string
+	Pkg  *packets.CmdPacket
+	Resp interface{}
+	Err  error
+}
+
+type broadcastKey struct{}
+
+// InvokeAll call every connected host in parallel, results keyed by server id.
+// err is set if there is no connected host or the quorum is not reached.
+func (r *RPCCliPool) InvokeAll(ctx context.Context, cmdid uint64, req interface{}, opts FanOutOpts) (results map[string]*HostResult, err error) {
+	return r.fanOut(ctx, opts, func(ctx context.Context, info *ClientConnInfo) *HostResult {
+		res := &HostResult{Host: info.urladdr}
+		res.Pkg, res.Resp, res.Err = r.invoke(ctx, info, cmdid, req)
+		return res
+	})
+}
+
+// Broadcast InvokeAsync every connected host in parallel, results keyed by server id.
+func (r *RPCCliPool) Broadcast(ctx context.Context, cmdid uint64, req interface{}, opts FanOutOpts) (results map[string]*HostResult, err error) {
+	return r.fanOut(ctx, opts, func(ctx context.Context, info *ClientConnInfo) *HostResult {
+		return &HostResult{Host: info.urladdr, Err: r.invokeAsync(ctx, info, cmdid, req)}
+	})
+}
+
+func (r *RPCCliPool) fanOut(ctx context.Context, opts FanOutOpts, call func(ctx context.Context, info *ClientConnInfo) *HostResult) (results map[string]*HostResult, err error) {
+	var hosts []*ClientConnInfo
+	for _, h := range r.allHosts() {
+		if h.connected() && h.Draining() == false {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultFanOutConcurrency
+	}
+	if opts.Quorum > len(hosts) {
+		err = fmt.Errorf("quorum %d > hosts %d", opts.Quorum, len(hosts))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, broadcastKey{}, true))
+	defer cancel()
+
+	type hostRes struct {
+		serverID string
+		res      *HostResult
+	}
+	ch := make(chan hostRes, len(hosts))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h *ClientConnInfo) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				ch <- hostRes{h.url.Hostname(), &HostResult{Host: h.urladdr, Err: ctx.Err()}}
+				return
+			}
+			res := call(ctx, h)
+			<-sem
+			ch <- hostRes{h.url.Hostname(), res}
+		}(h)
+	}
+
+	results = make(map[string]*HostResult, len(hosts))
+	var ok, fail int
+	for i := 0; i < len(hosts); i++ {
+		v := <-ch
+		results[v.serverID] = v.res
+		if v.res.Err == nil {
+			ok++
+		} else {
+			fail++
+		}
+		if opts.Quorum == 0 {
+			continue
+		}
+		if ok >= opts.Quorum {
+			return
+		}
+		if len(hosts)-fail < opts.Quorum {
+			break
+		}
+	}
+	if opts.Quorum > 0 && ok < opts.Quorum {
+		err = fmt.Errorf("quorum not reached: %d/%d succeeded, need %d", ok, len(hosts), opts.Quorum)
+	}
+	return
+}
+
+// InvokeAll call every connected host of the micro service.
+func (m *MicroClientConn) InvokeAll(ctx context.Context, ms string, cmdid uint64, req interface{}, opts FanOutOpts) (results map[string]*HostResult, err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	return v.RPCCliPool.InvokeAll(ctx, cmdid, req, opts)
+}
+
+// Broadcast InvokeAsync every connected host of the micro service.
+func (m *MicroClientConn) Broadcast(ctx context.Context, ms string, cmdid uint64, req interface{}, opts FanOutOpts) (results map[string]*HostResult, err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	return v.RPCCliPool.Broadcast(ctx, cmdid, req, opts)
+}
@@ -0,0 +1,128 @@
+package pprpcpool
+
+// 合并相同的并发请求(singleflight)
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pprpc/core/packets"
+)
+
+// CoalesceKeyFunc return the key identifying duplicate requests, ok false: do not coalesce req.
+type CoalesceKeyFunc func(req interface{}) (key string, ok bool)
+
+// flightCall in-flight call shared by the waiters
+type flightCall struct {
+	done chan struct{}
+
+	pkg  *packets.CmdPacket
+	resp interface{}
+	err  error
+}
+
+// flightGroup one call per key in flight
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do call fn once for concurrent callers of key, all get its result.
+// a waiter whose ctx is still alive calls again if the shared call
+// failed by the context of its caller.
+func (g *flightGroup) do(ctx context.Context, key string, fn invokeFunc) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*flightCall)
+		}
+		if c, ok := g.calls[key]; ok {
+			g.mu.Unlock()
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+			if (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+				continue
+			}
+			return c.pkg, c.resp, c.err
+		}
+		c := new(flightCall)
+		c.done = make(chan struct{})
+		g.calls[key] = c
+		g.mu.Unlock()
+
+		g.call(ctx, key, c, fn)
+		return c.pkg, c.resp, c.err
+	}
+}
+
+// call run fn of the leader and release the waiters,
+// if fn panics the waiters get an error and the panic goes on in the leader.
+func (g *flightGroup) call(ctx context.Context, key string, c *flightCall, fn invokeFunc) {
+	defer func() {
+		p := recover()
+		if p != nil {
+			c.err = fmt.Errorf("coalesced call panic: %v", p)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+		if p != nil {
+			panic(p)
+		}
+	}()
+	c.pkg, c.resp, c.err = fn(ctx)
+}
+
+// SetCoalesce coalesce identical in-flight Invoke of the micro cmdid, fn returns the request key,
+// waiters share the response, do not modify it.
+func (m *MicroClientConn) SetCoalesce(ms string, cmdid uint64, fn CoalesceKeyFunc) (err error) {
+	if fn == nil {
+		err = fmt.Errorf("SetCoalesce, error: not set key func")
+		return
+	}
+	m.updateCoalesce(func(fns map[cmdKey]CoalesceKeyFunc) {
+		fns[cmdKey{ms, cmdid}] = fn
+	})
+	return
+}
+
+// DelCoalesce stop coalescing Invoke of the micro cmdid.
+func (m *MicroClientConn) DelCoalesce(ms string, cmdid uint64) {
+	m.updateCoalesce(func(fns map[cmdKey]CoalesceKeyFunc) {
+		delete(fns, cmdKey{ms, cmdid})
+	})
+}
+
+func (m *MicroClientConn) updateCoalesce(fn func(fns map[cmdKey]CoalesceKeyFunc)) {
+	m.coalesceMu.Lock()
+	defer m.coalesceMu.Unlock()
+	old, _ := m.coalesce.Load().(map[cmdKey]CoalesceKeyFunc)
+	_t := make(map[cmdKey]CoalesceKeyFunc, len(old)+1)
+	for k, v := range old {
+		_t[k] = v
+	}
+	fn(_t)
+	m.coalesce.Store(_t)
+}
+
+// coalesceKey flight key of req, ok false if not coalesced.
+func (m *MicroClientConn) coalesceKey(ms string, cmdid uint64, req interface{}) (key string, ok bool) {
+	fns, _ := m.coalesce.Load().(map[cmdKey]CoalesceKeyFunc)
+	fn := fns[cmdKey{ms, cmdid}]
+	if fn == nil {
+		return
+	}
+	key, ok = fn(req)
+	if ok == false {
+		return
+	}
+	key = fmt.Sprintf("%s/%d/%s", ms, cmdid, key)
+	return
+}
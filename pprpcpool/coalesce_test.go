@@ -0,0 +1,66 @@
+package pprpcpool
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pprpc/core/packets"
+)
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	ctx := context.Background()
+	release := make(chan struct{})
+
+	leader := make(chan interface{})
+	go func() {
+		defer func() { leader <- recover() }()
+		g.do(ctx, "k", func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	for {
+		g.mu.Lock()
+		_, ok := g.calls["k"]
+		g.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	waiter := make(chan error)
+	go func() {
+		_, _, err := g.do(ctx, "k", func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+			return nil, nil, errors.New("waiter called fn")
+		})
+		waiter <- err
+	}()
+	// let the waiter join the call
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if p := <-leader; p != "boom" {
+		t.Fatalf("leader recovered %v, want the panic", p)
+	}
+	select {
+	case err := <-waiter:
+		if err == nil || strings.Contains(err.Error(), "panic") == false {
+			t.Fatalf("waiter err = %v, want the panic error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter stuck after the leader panicked")
+	}
+
+	// the key is released
+	_, resp, err := g.do(ctx, "k", func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+		return nil, "ok", nil
+	})
+	if err != nil || resp != "ok" {
+		t.Fatalf("do() after panic = %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,84 @@
+package pprpcpool
+
+// 节点优雅下线
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/util/logs"
+)
+
+// DefaultDrainTimeout drain deadline used by MicroClientConn.DelHost
+const DefaultDrainTimeout = 10 * time.Second
+
+// drainPoll interval checking in-flight calls of a draining host
+const drainPoll = 20 * time.Millisecond
+
+// DrainHost remove the host from selection, wait for in-flight calls to finish
+// up to timeout, then close the connections. done is closed after the close,
+// Close of the pool closes draining hosts at once.
+func (r *RPCCliPool) DrainHost(addr string, timeout time.Duration) (done <-chan struct{}, err error) {
+	info, err := r.takeHost(addr, true)
+	if err != nil {
+		return
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		r.drain(info, timeout)
+		close(ch)
+	}()
+	done = ch
+	return
+}
+
+func (r *RPCCliPool) drain(info *ClientConnInfo, timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	t := time.NewTicker(drainPoll)
+	defer t.Stop()
+
+wait:
+	for info.Inflight() > 0 {
+		select {
+		case <-r.ctx.Done():
+			break wait
+		case <-deadline.C:
+			logs.Logger.Warnf("DrainHost(%s), timeout, in-flight: %d.", info.urladdr, info.Inflight())
+			break wait
+		case <-t.C:
+		}
+	}
+	info.shutdown()
+	// re-added while draining, keep its series
+	if mt := r.getMetrics(); mt != nil {
+		if _, e := r.clis.Get(info.urladdr); e != nil {
+			mt.delHost(r.micro, info.urladdr)
+		}
+	}
+}
+
+// Draining the host is draining, removed from the pool
+func (c *ClientConnInfo) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// SetDrainTimeout drain deadline of hosts deleted by DelHost, 0: close at once.
+func (m *MicroClientConn) SetDrainTimeout(d time.Duration) (err error) {
+	if d < 0 {
+		err = fmt.Errorf("SetDrainTimeout, error: %s out of range", d)
+		return
+	}
+	m.mu.Lock()
+	m.drainTimeout = d
+	m.mu.Unlock()
+	return
+}
+
+func (m *MicroClientConn) getDrainTimeout() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.drainTimeout
+}
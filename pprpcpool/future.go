@@ -0,0 +1,109 @@
+package pprpcpool
+
+// 异步调用, Future/回调获取响应
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pprpc/core/packets"
+)
+
+// Callback called once with the call result
+type Callback func(pkg *packets.CmdPacket, resp interface{}, err error)
+
+// Future result of an async call, resolved once the call returns,
+// Invoke returns on the response, an error or the context being done.
+type Future struct {
+	done chan struct{}
+	once sync.Once
+	cb   Callback
+
+	pkg  *packets.CmdPacket
+	resp interface{}
+	err  error
+}
+
+func newFuture(cb Callback) *Future {
+	f := new(Future)
+	f.done = make(chan struct{})
+	f.cb = cb
+	return f
+}
+
+// Done closed when the future is resolved
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result wait and return the call result.
+func (f *Future) Result() (pkg *packets.CmdPacket, resp interface{}, err error) {
+	<-f.done
+	return f.pkg, f.resp, f.err
+}
+
+// Wait wait the call result up to ctx, the call is not canceled if ctx is done.
+func (f *Future) Wait(ctx context.Context) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	select {
+	case <-f.done:
+		return f.pkg, f.resp, f.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+}
+
+func (f *Future) resolve(pkg *packets.CmdPacket, resp interface{}, err error) {
+	f.once.Do(func() {
+		f.pkg, f.resp, f.err = pkg, resp, err
+		close(f.done)
+		if f.cb != nil {
+			f.cb(pkg, resp, err)
+		}
+	})
+}
+
+type invokeFunc func(ctx context.Context) (*packets.CmdPacket, interface{}, error)
+
+// goFuture run the blocking call in one goroutine, the future resolves when it returns.
+// pprpc InvokeAsync carries no response, the pool can not match one to the request,
+// so the future waits on Invoke.
+func goFuture(ctx context.Context, cb Callback, call invokeFunc) *Future {
+	f := newFuture(cb)
+	if err := ctx.Err(); err != nil {
+		f.resolve(nil, nil, err)
+		return f
+	}
+	go func() {
+		f.resolve(call(ctx))
+	}()
+	return f
+}
+
+// InvokeFuture call Invoke in background and return a future of the response.
+func (r *RPCCliPool) InvokeFuture(ctx context.Context, cmdid uint64, req interface{}) *Future {
+	return goFuture(ctx, nil, func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+		return r.Invoke(ctx, cmdid, req)
+	})
+}
+
+// InvokeCallback call Invoke in background, cb is called once with the response or error.
+func (r *RPCCliPool) InvokeCallback(ctx context.Context, cmdid uint64, req interface{}, cb Callback) {
+	goFuture(ctx, cb, func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+		return r.Invoke(ctx, cmdid, req)
+	})
+}
+
+// InvokeFuture call the micro service in background and return a future of the response.
+func (m *MicroClientConn) InvokeFuture(ctx context.Context, ms string, cmdid uint64, req interface{}) *Future {
+	return goFuture(ctx, nil, func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+		return m.Invoke(ctx, ms, cmdid, req)
+	})
+}
+
+// InvokeCallback call the micro service in background, cb is called once with the response or error.
+func (m *MicroClientConn) InvokeCallback(ctx context.Context, ms string, cmdid uint64, req interface{}, cb Callback) {
+	goFuture(ctx, cb, func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+		return m.Invoke(ctx, ms, cmdid, req)
+	})
+}
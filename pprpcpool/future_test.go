@@ -0,0 +1,60 @@
+package pprpcpool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pprpc/core/packets"
+)
+
+func TestInvokeFuture(t *testing.T) {
+	r := newTestPool(t, 1)
+	r.SetInterceptors(func(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (*packets.CmdPacket, interface{}, error) {
+		return nil, req, nil
+	})
+
+	f := r.InvokeFuture(context.Background(), 1, "ping")
+	if _, resp, err := f.Result(); err != nil || resp != "ping" {
+		t.Fatalf("Result() = %v, %v, want ping", resp, err)
+	}
+
+	got := make(chan interface{}, 2)
+	r.InvokeCallback(context.Background(), 1, "pong", func(pkg *packets.CmdPacket, resp interface{}, err error) {
+		got <- resp
+	})
+	if resp := <-got; resp != "pong" {
+		t.Fatalf("callback resp = %v, want pong", resp)
+	}
+	select {
+	case resp := <-got:
+		t.Fatalf("callback called twice, %v", resp)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// the future resolves when Invoke returns on the context being done
+func TestInvokeFutureCanceled(t *testing.T) {
+	r := newTestPool(t, 1)
+	r.SetInterceptors(func(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (*packets.CmdPacket, interface{}, error) {
+		<-ctx.Done()
+		return nil, nil, fmt.Errorf("invoke, %w", ctx.Err())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := r.InvokeFuture(ctx, 1, nil)
+	select {
+	case <-f.Done():
+		t.Fatalf("future resolved before the context is done")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	if _, _, err := f.Result(); err == nil || err.Error() != "invoke, context canceled" {
+		t.Fatalf("Result() = %v, want the Invoke error", err)
+	}
+
+	if _, _, err := r.InvokeFuture(ctx, 1, nil).Result(); err != context.Canceled {
+		t.Fatalf("Result() with ctx done = %v, want context.Canceled", err)
+	}
+}
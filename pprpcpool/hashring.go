@@ -0,0 +1,61 @@
+package pprpcpool
+
+// 一致性哈希
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultHashReplicas virtual nodes per host
+const DefaultHashReplicas = 160
+
+// hashRing consistent hash ring, immutable after create.
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]string
+	count  int
+}
+
+func newHashRing(replicas int, addrs []string) *hashRing {
+	if replicas <= 0 {
+		replicas = DefaultHashReplicas
+	}
+	h := new(hashRing)
+	h.nodes = make(map[uint32]string, replicas*len(addrs))
+	h.count = len(addrs)
+	for _, addr := range addrs {
+		for i := 0; i < replicas; i++ {
+			v := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + addr))
+			if _, ok := h.nodes[v]; ok {
+				continue
+			}
+			h.nodes[v] = addr
+			h.hashes = append(h.hashes, v)
+		}
+	}
+	sort.Slice(h.hashes, func(i, j int) bool { return h.hashes[i] < h.hashes[j] })
+	return h
+}
+
+// walk visit hosts clockwise from key, each host once, until fn return true.
+func (h *hashRing) walk(key string, fn func(addr string) bool) {
+	if h == nil || len(h.hashes) == 0 {
+		return
+	}
+	v := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(h.hashes), func(i int) bool { return h.hashes[i] >= v })
+
+	seen := make(map[string]bool, h.count)
+	for i := 0; i < len(h.hashes) && len(seen) < h.count; i++ {
+		addr := h.nodes[h.hashes[(idx+i)%len(h.hashes)]]
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		if fn(addr) {
+			return
+		}
+	}
+}
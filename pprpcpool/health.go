@@ -0,0 +1,167 @@
+package pprpcpool
+
+// 主动健康检查
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/util/logs"
+)
+
+// HealthCheck active health check config
+type HealthCheck struct {
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int // consecutive successes to readmit
+	UnhealthyThreshold int // consecutive failures to eject
+	// CmdID ping cmdid, 0: tcp connect check
+	CmdID uint64
+	Req   func() interface{}
+}
+
+// healthState per host health check state, only used by the checker.
+type healthState struct {
+	success int
+	fail    int
+}
+
+func (c *ClientConnInfo) healthy() bool {
+	return atomic.LoadInt32(&c.unhealthy) == 0
+}
+
+// Healthy false if ejected by health check
+func (c *ClientConnInfo) Healthy() bool {
+	return c.healthy()
+}
+
+// StartHealthCheck probe all hosts periodically, replace the running check.
+func (r *RPCCliPool) StartHealthCheck(hc HealthCheck) (err error) {
+	if hc.Interval < 100*time.Millisecond {
+		err = fmt.Errorf("StartHealthCheck, error: Interval must be >= 100ms")
+		return
+	}
+	if hc.CmdID != 0 && hc.Req == nil {
+		err = fmt.Errorf("StartHealthCheck, error: not set Req")
+		return
+	}
+	if hc.Timeout <= 0 || hc.Timeout > hc.Interval {
+		hc.Timeout = hc.Interval
+	}
+	if hc.HealthyThreshold < 1 {
+		hc.HealthyThreshold = 1
+	}
+	if hc.UnhealthyThreshold < 1 {
+		hc.UnhealthyThreshold = 1
+	}
+
+	r.StopHealthCheck()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.mu.Lock()
+	r.hcCancel = cancel
+	r.mu.Unlock()
+
+	go r.healthCheck(ctx, hc)
+	return
+}
+
+// StopHealthCheck stop health check, all hosts are healthy again.
+func (r *RPCCliPool) StopHealthCheck() {
+	r.mu.Lock()
+	cancel := r.hcCancel
+	r.hcCancel = nil
+	hosts := r.allHosts()
+	r.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	for _, h := range hosts {
+		atomic.StoreInt32(&h.unhealthy, 0)
+	}
+}
+
+func (r *RPCCliPool) healthCheck(ctx context.Context, hc HealthCheck) {
+	states := make(map[*ClientConnInfo]*healthState)
+	t := time.NewTicker(hc.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		hosts := r.allHosts()
+
+		cur := make(map[*ClientConnInfo]*healthState, len(hosts))
+		var wg sync.WaitGroup
+		for _, h := range hosts {
+			st, ok := states[h]
+			if ok == false {
+				st = new(healthState)
+			}
+			cur[h] = st
+			// disconnected host is handled by reconnect
+			if h.connected() == false {
+				continue
+			}
+			wg.Add(1)
+			go func(h *ClientConnInfo, st *healthState) {
+				defer wg.Done()
+				r.checkHealth(ctx, hc, h, st)
+			}(h, st)
+		}
+		wg.Wait()
+		states = cur
+	}
+}
+
+func (r *RPCCliPool) checkHealth(ctx context.Context, hc HealthCheck, h *ClientConnInfo, st *healthState) {
+	err := r.probeHealth(ctx, hc, h)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		st.success = 0
+		st.fail++
+		if st.fail >= hc.UnhealthyThreshold && atomic.CompareAndSwapInt32(&h.unhealthy, 0, 1) {
+			logs.Logger.Warnf("health check %s failed %d times, ejected, %s.", h.urladdr, st.fail, err)
+		}
+		return
+	}
+	st.fail = 0
+	st.success++
+	if st.success >= hc.HealthyThreshold && atomic.CompareAndSwapInt32(&h.unhealthy, 1, 0) {
+		logs.Logger.Infof("health check %s ok %d times, readmitted.", h.urladdr, st.success)
+	}
+}
+
+func (r *RPCCliPool) probeHealth(ctx context.Context, hc HealthCheck, h *ClientConnInfo) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	if hc.CmdID == 0 {
+		var d net.Dialer
+		conn, e := d.DialContext(ctx, "tcp", h.url.Host)
+		if e != nil {
+			err = e
+			return
+		}
+		conn.Close()
+		return
+	}
+	cli := h.getConn()
+	if cli == nil {
+		err = fmt.Errorf("Connection is closed: %s", h.urladdr)
+		return
+	}
+	_, _, err = cli.Invoke(ctx, hc.CmdID, hc.Req())
+	return
+}
@@ -0,0 +1,212 @@
+package pprpcpool
+
+// 对冲请求
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/core/packets"
+)
+
+const hedgeSamples = 1000
+
+// HedgePolicy hedge policy of a cmdid, only set it for idempotent read cmdids.
+// a second request is sent to another host when the first has not answered
+// within the delay, the first answer wins and the other is canceled.
+type HedgePolicy struct {
+	Delay      time.Duration // fixed delay, or the initial delay when Percentile is set
+	Percentile float64       // 0-1, eg: 0.95, delay = the observed latency percentile of the cmdid
+	MinDelay   time.Duration // lower bound of the percentile delay
+}
+
+// HedgeStats hedge metrics of a cmdid
+type HedgeStats struct {
+	Requests  uint64 // calls with the hedge policy
+	Hedged    uint64 // hedge request sent
+	HedgeWins uint64 // hedge request answered first
+}
+
+type hedgeState struct {
+	requests  uint64
+	hedged    uint64
+	hedgeWins uint64
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int
+	added   int
+	delay   int64 // cached percentile delay, ns
+}
+
+func (s *hedgeState) record(p HedgePolicy, d time.Duration) {
+	if p.Percentile <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.samples == nil {
+		s.samples = make([]time.Duration, hedgeSamples)
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % hedgeSamples
+	if s.count < hedgeSamples {
+		s.count++
+	}
+	// recompute every 100 samples
+	s.added++
+	if s.added%100 != 0 {
+		return
+	}
+	_t := make([]time.Duration, s.count)
+	copy(_t, s.samples[:s.count])
+	sort.Slice(_t, func(i, j int) bool { return _t[i] < _t[j] })
+	idx := int(float64(len(_t)-1) * p.Percentile)
+	atomic.StoreInt64(&s.delay, int64(_t[idx]))
+}
+
+func (s *hedgeState) getDelay(p HedgePolicy) time.Duration {
+	d := p.Delay
+	if p.Percentile > 0 {
+		if v := atomic.LoadInt64(&s.delay); v > 0 {
+			d = time.Duration(v)
+		}
+		if d < p.MinDelay {
+			d = p.MinDelay
+		}
+	}
+	return d
+}
+
+// SetHedgePolicy set hedge policy of cmdid, it take precedence over the retry policy.
+func (r *RPCCliPool) SetHedgePolicy(cmdid uint64, p HedgePolicy) (err error) {
+	if p.Percentile < 0 || p.Percentile >= 1 {
+		err = fmt.Errorf("SetHedgePolicy, error: Percentile out of range: 0-1")
+		return
+	}
+	if p.Delay <= 0 {
+		err = fmt.Errorf("SetHedgePolicy, error: not set Delay")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.hedgePolicies.Load().(map[uint64]HedgePolicy)
+	m := make(map[uint64]HedgePolicy, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[cmdid] = p
+	r.hedgePolicies.Store(m)
+	return
+}
+
+// DelHedgePolicy .
+func (r *RPCCliPool) DelHedgePolicy(cmdid uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.hedgePolicies.Load().(map[uint64]HedgePolicy)
+	m := make(map[uint64]HedgePolicy, len(old))
+	for k, v := range old {
+		if k != cmdid {
+			m[k] = v
+		}
+	}
+	r.hedgePolicies.Store(m)
+}
+
+// GetHedgeStats .
+func (r *RPCCliPool) GetHedgeStats(cmdid uint64) (stats HedgeStats) {
+	v, ok := r.hedgeStates.Load(cmdid)
+	if ok == false {
+		return
+	}
+	s := v.(*hedgeState)
+	stats.Requests = atomic.LoadUint64(&s.requests)
+	stats.Hedged = atomic.LoadUint64(&s.hedged)
+	stats.HedgeWins = atomic.LoadUint64(&s.hedgeWins)
+	return
+}
+
+func (r *RPCCliPool) getHedgePolicy(cmdid uint64) (p HedgePolicy, ok bool) {
+	m, _ := r.hedgePolicies.Load().(map[uint64]HedgePolicy)
+	p, ok = m[cmdid]
+	return
+}
+
+type hedgeResult struct {
+	pkg   *packets.CmdPacket
+	resp  interface{}
+	err   error
+	hedge bool
+}
+
+func (r *RPCCliPool) invokeHedge(ctx context.Context, p HedgePolicy, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	v, _ := r.hedgeStates.LoadOrStore(cmdid, new(hedgeState))
+	st := v.(*hedgeState)
+	atomic.AddUint64(&st.requests, 1)
+
+	var first *ClientConnInfo
+	first, err = r.lbs(nil)
+	if first == nil || err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, hedgedKey{}, true))
+	defer cancel()
+
+	ch := make(chan hedgeResult, 2)
+	call := func(info *ClientConnInfo, hedge bool) {
+		var res hedgeResult
+		res.pkg, res.resp, res.err = r.invoke(ctx, info, cmdid, req)
+		res.hedge = hedge
+		ch <- res
+	}
+
+	start := time.Now()
+	pending := 1
+	go call(first, false)
+
+	t := time.NewTimer(st.getDelay(p))
+	defer t.Stop()
+	select {
+	case res := <-ch:
+		if res.err == nil {
+			st.record(p, time.Since(start))
+		}
+		return res.pkg, res.resp, res.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	case <-t.C:
+		second, e := r.lbs(map[*ClientConnInfo]bool{first: true})
+		if e == nil && second != nil && second != first {
+			atomic.AddUint64(&st.hedged, 1)
+			pending++
+			go call(second, true)
+		}
+	}
+
+	var res hedgeResult
+	for pending > 0 {
+		res = <-ch
+		pending--
+		if res.err == nil {
+			break
+		}
+	}
+	if res.err == nil {
+		st.record(p, time.Since(start))
+		if res.hedge {
+			atomic.AddUint64(&st.hedgeWins, 1)
+		}
+	}
+	return res.pkg, res.resp, res.err
+}
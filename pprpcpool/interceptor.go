@@ -0,0 +1,93 @@
+package pprpcpool
+
+// 调用拦截器
+
+import (
+	"context"
+
+	"github.com/pprpc/core/packets"
+)
+
+// InvokeInfo call info passed to interceptors
+type InvokeInfo struct {
+	Micro    string // micro service name, empty if the pool is not added by MicroClientConn
+	CmdID    uint64
+	Host     string // chosen host url
+	ServerID string
+	Async    bool // InvokeAsync, no response packet
+	Hedged   bool // req is shared by concurrent hedged calls, do not modify it
+	Fanout   bool // InvokeAll/Broadcast, req is shared by all hosts, do not modify it
+}
+
+// Invoker do the call, or call the next interceptor
+type Invoker func(ctx context.Context, info *InvokeInfo, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error)
+
+// Interceptor unary client interceptor, call invoker to continue the chain.
+// it runs once per attempt after the host is chosen.
+type Interceptor func(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (pkg *packets.CmdPacket, resp interface{}, err error)
+
+// SetInterceptors set the interceptor chain of the pool, the first is the outermost.
+func (r *RPCCliPool) SetInterceptors(ics ...Interceptor) {
+	_t := make([]Interceptor, len(ics))
+	copy(_t, ics)
+	r.interceptors.Store(_t)
+}
+
+func (r *RPCCliPool) intercept(ctx context.Context, info *InvokeInfo, req interface{}, final Invoker) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	ics, _ := r.interceptors.Load().([]Interceptor)
+	if len(ics) == 0 {
+		return final(ctx, info, req)
+	}
+	return chainInterceptors(ics, final)(ctx, info, req)
+}
+
+func chainInterceptors(ics []Interceptor, final Invoker) Invoker {
+	invoker := final
+	for i := len(ics) - 1; i >= 0; i-- {
+		ic, next := ics[i], invoker
+		invoker = func(ctx context.Context, info *InvokeInfo, req interface{}) (*packets.CmdPacket, interface{}, error) {
+			return ic(ctx, info, req, next)
+		}
+	}
+	return invoker
+}
+
+type hedgedKey struct{}
+
+func (r *RPCCliPool) newInvokeInfo(ctx context.Context, h *ClientConnInfo, cmdid uint64, async bool) *InvokeInfo {
+	return &InvokeInfo{
+		Micro:    r.micro,
+		CmdID:    cmdid,
+		Host:     h.urladdr,
+		ServerID: h.url.Hostname(),
+		Async:    async,
+		Hedged:   ctx.Value(hedgedKey{}) != nil,
+		Fanout:   ctx.Value(broadcastKey{}) != nil,
+	}
+}
+
+// Use add interceptors for all micro services, run before the per micro ones.
+func (m *MicroClientConn) Use(ics ...Interceptor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interceptors = append(m.interceptors, ics...)
+	for _, v := range m.list() {
+		m.applyInterceptors(v)
+	}
+}
+
+// SetInterceptors set interceptors of the micro service.
+func (m *MicroClientConn) SetInterceptors(ms string, ics ...Interceptor) (err error) {
+	return m.updateMicro(ms, func(cp *ClientPool) {
+		cp.interceptors = ics
+		m.applyInterceptors(cp)
+	})
+}
+
+// applyInterceptors must hold m.mu
+func (m *MicroClientConn) applyInterceptors(cp *ClientPool) {
+	var ics []Interceptor
+	ics = append(ics, m.interceptors...)
+	ics = append(ics, cp.interceptors...)
+	cp.RPCCliPool.SetInterceptors(ics...)
+}
@@ -0,0 +1,238 @@
+package pprpcpool
+
+// 自适应并发限制
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// limit algorithm
+const (
+	LimitAIMD     = "aimd"
+	LimitGradient = "gradient"
+)
+
+// ConcurrencyLimit per host in-flight limit adapting to latency.
+// aimd: +1 per limit successes, *Backoff on timeout.
+// gradient: limit*min(1, Tolerance*longRTT/rtt) + sqrt(limit), smoothed.
+type ConcurrencyLimit struct {
+	Algorithm    string        // LimitAIMD(default), LimitGradient
+	InitialLimit int           // default 20
+	MinLimit     int           // default 1
+	MaxLimit     int           // default 1000
+	Backoff      float64       // aimd, (0, 1), default 0.9
+	Tolerance    float64       // gradient, >= 1, default 2
+	Smoothing    float64       // gradient, (0, 1], default 0.2
+	QueueTimeout time.Duration // wait for a free slot up to, 0: reject at once
+}
+
+// OverloadError call rejected by the host concurrency limit
+type OverloadError struct {
+	Name  string
+	Limit int
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("concurrency limit exceeded: %s(%d)", e.Name, e.Limit)
+}
+
+// IsOverload .
+func IsOverload(err error) bool {
+	var e *OverloadError
+	return errors.As(err, &e)
+}
+
+// limiter in-flight limit of a host
+type limiter struct {
+	name string
+	conf ConcurrencyLimit
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	waiters  int
+	wake     chan struct{}
+	longRTT  float64 // ns, ewma
+}
+
+func newLimiter(name string, conf ConcurrencyLimit) *limiter {
+	l := new(limiter)
+	l.name = name
+	l.conf = conf
+	l.limit = float64(conf.InitialLimit)
+	l.wake = make(chan struct{})
+	return l
+}
+
+// acquire return *OverloadError if no slot is free within QueueTimeout, or release must be called.
+func (l *limiter) acquire(ctx context.Context) (err error) {
+	var timer *time.Timer
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			break
+		}
+		limit := int(l.limit)
+		if l.conf.QueueTimeout <= 0 {
+			l.mu.Unlock()
+			err = &OverloadError{Name: l.name, Limit: limit}
+			break
+		}
+		if timer == nil {
+			timer = time.NewTimer(l.conf.QueueTimeout)
+		}
+		wake := l.wake
+		l.waiters++
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			err = &OverloadError{Name: l.name, Limit: limit}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		l.mu.Lock()
+		l.waiters--
+		l.mu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	return
+}
+
+// release free the slot, sample: adapt the limit by the call result.
+func (l *limiter) release(rtt time.Duration, err error, sample bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	if sample && errors.Is(err, context.Canceled) == false {
+		l.update(rtt, err)
+	}
+	if l.waiters > 0 {
+		close(l.wake)
+		l.wake = make(chan struct{})
+	}
+}
+
+// update must hold l.mu
+func (l *limiter) update(rtt time.Duration, err error) {
+	c := l.conf
+	switch c.Algorithm {
+	case LimitGradient:
+		if err != nil && isTimeout(err) == false {
+			return
+		}
+		sample := float64(rtt)
+		if err != nil {
+			// timed out, decrease as much as allowed
+			sample = math.Max(sample, 2*c.Tolerance*l.longRTT)
+		}
+		if l.longRTT == 0 {
+			l.longRTT = sample
+		} else {
+			l.longRTT += (sample - l.longRTT) / 100
+		}
+		if sample <= 0 {
+			return
+		}
+		gradient := math.Max(0.5, math.Min(1, c.Tolerance*l.longRTT/sample))
+		limit := l.limit*gradient + math.Sqrt(l.limit)
+		l.limit = l.limit*(1-c.Smoothing) + limit*c.Smoothing
+	default:
+		if err != nil {
+			if isTimeout(err) {
+				l.limit *= c.Backoff
+			}
+		} else {
+			l.limit += 1 / l.limit
+		}
+	}
+	l.limit = math.Max(float64(c.MinLimit), math.Min(float64(c.MaxLimit), l.limit))
+}
+
+func (l *limiter) getLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// SetConcurrencyLimit enable adaptive in-flight limit per host.
+func (r *RPCCliPool) SetConcurrencyLimit(conf ConcurrencyLimit) (err error) {
+	if conf.Algorithm == "" {
+		conf.Algorithm = LimitAIMD
+	}
+	if conf.Algorithm != LimitAIMD && conf.Algorithm != LimitGradient {
+		err = fmt.Errorf("SetConcurrencyLimit, error: unknown algorithm %s", conf.Algorithm)
+		return
+	}
+	if conf.MinLimit <= 0 {
+		conf.MinLimit = 1
+	}
+	if conf.MaxLimit <= 0 {
+		conf.MaxLimit = 1000
+	}
+	if conf.InitialLimit <= 0 {
+		conf.InitialLimit = 20
+	}
+	if conf.MinLimit > conf.MaxLimit || conf.InitialLimit < conf.MinLimit || conf.InitialLimit > conf.MaxLimit {
+		err = fmt.Errorf("SetConcurrencyLimit, error: MinLimit <= InitialLimit <= MaxLimit")
+		return
+	}
+	if conf.Backoff == 0 {
+		conf.Backoff = 0.9
+	}
+	if conf.Tolerance == 0 {
+		conf.Tolerance = 2
+	}
+	if conf.Smoothing == 0 {
+		conf.Smoothing = 0.2
+	}
+	if conf.Backoff <= 0 || conf.Backoff >= 1 || conf.Tolerance < 1 || conf.Smoothing < 0 || conf.Smoothing > 1 || conf.QueueTimeout < 0 {
+		err = fmt.Errorf("SetConcurrencyLimit, error: Backoff/Tolerance/Smoothing/QueueTimeout out of range")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limitConf = &conf
+	for _, h := range r.allHosts() {
+		h.limiter.Store(newLimiter(h.urladdr, conf))
+	}
+	return
+}
+
+// DelConcurrencyLimit disable the in-flight limit.
+func (r *RPCCliPool) DelConcurrencyLimit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limitConf = nil
+	for _, h := range r.allHosts() {
+		h.limiter.Store((*limiter)(nil))
+	}
+}
+
+func (c *ClientConnInfo) getLimiter() *limiter {
+	l, _ := c.limiter.Load().(*limiter)
+	return l
+}
+
+// Limit current in-flight limit of the host, 0 if not limited.
+func (c *ClientConnInfo) Limit() int {
+	l := c.getLimiter()
+	if l == nil {
+		return 0
+	}
+	return l.getLimit()
+}
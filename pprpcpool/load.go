@@ -0,0 +1,246 @@
+package pprpcpool
+
+// 负载采集与负载感知均衡
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pprpc/util/logs"
+)
+
+// LoadProbe collect host load by a stats cmdid
+type LoadProbe struct {
+	CmdID    uint64
+	Req      func() interface{}
+	Interval time.Duration
+	Timeout  time.Duration
+	// Parse decode the stats response to cpu, mem, sys.
+	Parse func(resp interface{}) (cpu, mem, sys []float32, err error)
+}
+
+// GetLoad return host load and the update time, zero time if never reported
+func (c *ClientConnInfo) GetLoad() (cpu, mem, sys []float32, t time.Time) {
+	c.loadMu.RLock()
+	defer c.loadMu.RUnlock()
+	return c.CPU, c.Mem, c.Sys, c.loadTime
+}
+
+func (c *ClientConnInfo) setLoad(cpu, mem, sys []float32) {
+	c.loadMu.Lock()
+	c.CPU = cpu
+	c.Mem = mem
+	c.Sys = sys
+	c.loadTime = time.Now()
+	c.loadMu.Unlock()
+}
+
+// UpdateLoad set host load, eg: from the register value
+func (r *RPCCliPool) UpdateLoad(addr string, cpu, mem, sys []float32) (err error) {
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		err = fmt.Errorf("Load(%s), %s", addr, e)
+		return
+	}
+	v.(*ClientConnInfo).setLoad(cpu, mem, sys)
+	return
+}
+
+// StartLoadProbe collect load of all connected hosts periodically, replace the running probe, stop by StopLoadProbe or Close.
+func (r *RPCCliPool) StartLoadProbe(p LoadProbe) (err error) {
+	if p.Req == nil || p.Parse == nil {
+		err = fmt.Errorf("StartLoadProbe, error: not set Req/Parse")
+		return
+	}
+	if p.Interval < time.Second {
+		err = fmt.Errorf("StartLoadProbe, error: Interval must be >= 1s")
+		return
+	}
+	if p.Timeout <= 0 || p.Timeout > p.Interval {
+		p.Timeout = p.Interval
+	}
+
+	r.StopLoadProbe()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.mu.Lock()
+	r.lpCancel = cancel
+	r.mu.Unlock()
+
+	go r.loadProbe(ctx, p)
+	return
+}
+
+// StopLoadProbe stop the load probe, the collected load is kept.
+func (r *RPCCliPool) StopLoadProbe() {
+	r.mu.Lock()
+	cancel := r.lpCancel
+	r.lpCancel = nil
+	r.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (r *RPCCliPool) loadProbe(ctx context.Context, p LoadProbe) {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			hosts := r.getHosts()
+
+			var wg sync.WaitGroup
+			for _, h := range hosts {
+				wg.Add(1)
+				go func(h *ClientConnInfo) {
+					defer wg.Done()
+					r.probeLoad(ctx, p, h)
+				}(h)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func (r *RPCCliPool) probeLoad(ctx context.Context, p LoadProbe, h *ClientConnInfo) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	cli := h.getConn()
+	if cli == nil {
+		return
+	}
+	_, resp, err := cli.Invoke(ctx, p.CmdID, p.Req())
+	if err != nil {
+		logs.Logger.Debugf("load probe %s, cmdid: %d, %s.", h.urladdr, p.CmdID, err)
+		return
+	}
+	cpu, mem, sys, err := p.Parse(resp)
+	if err != nil {
+		logs.Logger.Warnf("load probe %s, Parse(), %s.", h.urladdr, err)
+		return
+	}
+	h.setLoad(cpu, mem, sys)
+}
+
+// DefaultLoadScore score host load in 0-1, -1 if unknown.
+// the higher of cpu[0] usage and mem[2] used ratio, sys is not used.
+func DefaultLoadScore(cpu, mem, sys []float32) float64 {
+	score := -1.0
+	if len(cpu) > 0 {
+		score = normRatio(cpu[0])
+	}
+	if len(mem) > 2 {
+		if v := normRatio(mem[2]); v > score {
+			score = v
+		}
+	}
+	if score > 1 {
+		score = 1
+	}
+	return score
+}
+
+// normRatio accept 0-1 or 0-100
+func normRatio(v float32) float64 {
+	if v > 1 {
+		return float64(v) / 100
+	}
+	if v < 0 {
+		return 0
+	}
+	return float64(v)
+}
+
+// LoadAwareBalancer steer traffic away from overloaded hosts,
+// hosts are picked randomly weighted by their free capacity.
+type LoadAwareBalancer struct {
+	// Overload hosts at or above it only used when all hosts are overloaded, default 0.9
+	Overload float64
+	// MaxAge load older than it is unknown, default 30s
+	MaxAge time.Duration
+	// Score default DefaultLoadScore
+	Score func(cpu, mem, sys []float32) float64
+}
+
+// NewLoadAwareBalancer create load aware balancer
+func NewLoadAwareBalancer() *LoadAwareBalancer {
+	return &LoadAwareBalancer{Overload: 0.9, MaxAge: 30 * time.Second, Score: DefaultLoadScore}
+}
+
+// Pick .
+func (b *LoadAwareBalancer) Pick(hosts []*ClientConnInfo) (info *ClientConnInfo, err error) {
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found")
+		return
+	}
+	overload, maxAge, score := b.Overload, b.MaxAge, b.Score
+	if overload <= 0 {
+		overload = 0.9
+	}
+	if maxAge <= 0 {
+		maxAge = 30 * time.Second
+	}
+	if score == nil {
+		score = DefaultLoadScore
+	}
+
+	now := time.Now()
+	scores := make([]float64, len(hosts))
+	known, sum := 0, 0.0
+	for i, h := range hosts {
+		scores[i] = -1
+		cpu, mem, sys, t := h.GetLoad()
+		if t.IsZero() || now.Sub(t) > maxAge {
+			continue
+		}
+		scores[i] = score(cpu, mem, sys)
+		if scores[i] >= 0 {
+			known++
+			sum += scores[i]
+		}
+	}
+	// unknown host as average load
+	avg := 0.5
+	if known > 0 {
+		avg = sum / float64(known)
+	}
+
+	free := make([]float64, len(hosts))
+	total := 0.0
+	for i := range hosts {
+		if scores[i] < 0 {
+			scores[i] = avg
+		}
+		if scores[i] >= overload {
+			continue
+		}
+		// keep a floor, a busy host still get a little traffic
+		free[i] = 1 - scores[i] + 0.01
+		total += free[i]
+	}
+	if total == 0 {
+		// all overloaded
+		info = hosts[rand.Intn(len(hosts))]
+		return
+	}
+	n := rand.Float64() * total
+	for i := range hosts {
+		if free[i] == 0 {
+			continue
+		}
+		info = hosts[i]
+		n -= free[i]
+		if n < 0 {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,128 @@
+package pprpcpool
+
+// 就近路由, 优先本可用区/本地域
+
+import (
+	"fmt"
+)
+
+// Locality where a host runs
+type Locality struct {
+	Region string
+	Zone   string
+}
+
+// LocalityConf prefer hosts in the caller's zone, then region, then any.
+// a tier is used while its available hosts >= MinHealthyPercent of its hosts
+// and >= 1, otherwise calls spill over to the next tier.
+type LocalityConf struct {
+	Region            string // caller region
+	Zone              string // caller zone, empty: prefer region only
+	MinHealthyPercent int    // 0-100, 0: spill over only when no local host is available
+}
+
+// SetLocality enable locality aware routing.
+func (r *RPCCliPool) SetLocality(conf LocalityConf) (err error) {
+	err = conf.check()
+	if err != nil {
+		return
+	}
+	r.locality.Store(&conf)
+	return
+}
+
+func (conf LocalityConf) check() error {
+	if conf.Region == "" {
+		return fmt.Errorf("SetLocality, error: not set Region")
+	}
+	if conf.MinHealthyPercent < 0 || conf.MinHealthyPercent > 100 {
+		return fmt.Errorf("SetLocality, error: MinHealthyPercent(%d) out of range", conf.MinHealthyPercent)
+	}
+	return nil
+}
+
+// DelLocality disable locality aware routing.
+func (r *RPCCliPool) DelLocality() {
+	r.locality.Store((*LocalityConf)(nil))
+}
+
+func (r *RPCCliPool) getLocality() *LocalityConf {
+	l, _ := r.locality.Load().(*LocalityConf)
+	return l
+}
+
+// SetHostLocality set the locality of the host.
+func (r *RPCCliPool) SetHostLocality(addr string, l Locality) (err error) {
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		err = fmt.Errorf("SetHostLocality(%s), %s", addr, e)
+		return
+	}
+	v.(*ClientConnInfo).locality.Store(l)
+	return
+}
+
+// Locality of the host
+func (c *ClientConnInfo) Locality() Locality {
+	l, _ := c.locality.Load().(Locality)
+	return l
+}
+
+// preferLocal return available hosts of the nearest tier with enough capacity,
+// all: the candidate hosts in any state.
+func (r *RPCCliPool) preferLocal(all, avail []*ClientConnInfo) []*ClientConnInfo {
+	conf := r.getLocality()
+	if conf == nil || len(avail) == 0 {
+		return avail
+	}
+	if conf.Zone != "" {
+		if hosts := localTier(conf, all, avail, true); hosts != nil {
+			return hosts
+		}
+	}
+	if hosts := localTier(conf, all, avail, false); hosts != nil {
+		return hosts
+	}
+	return avail
+}
+
+// localTier nil if the tier does not have enough available hosts.
+func localTier(conf *LocalityConf, all, avail []*ClientConnInfo, zone bool) (hosts []*ClientConnInfo) {
+	match := func(h *ClientConnInfo) bool {
+		l := h.Locality()
+		return l.Region == conf.Region && (zone == false || l.Zone == conf.Zone)
+	}
+	total := 0
+	for _, h := range all {
+		if match(h) {
+			total++
+		}
+	}
+	for _, h := range avail {
+		if match(h) {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 || len(hosts)*100 < conf.MinHealthyPercent*total {
+		return nil
+	}
+	return hosts
+}
+
+// SetLocality enable locality aware routing of all micro services.
+func (m *MicroClientConn) SetLocality(conf LocalityConf) (err error) {
+	err = conf.check()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.list() {
+		err = v.SetLocality(conf)
+		if err != nil {
+			return
+		}
+	}
+	m.locality = &conf
+	return
+}
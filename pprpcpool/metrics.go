@@ -0,0 +1,196 @@
+package pprpcpool
+
+// Prometheus 指标
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// error class label
+const (
+	ErrClassTimeout     = "timeout"
+	ErrClassCanceled    = "canceled"
+	ErrClassCircuitOpen = "circuit_open"
+	ErrClassOverload    = "overload"
+	ErrClassOther       = "other"
+)
+
+// Metrics pool metrics, it is a prometheus.Collector, register it on a registry:
+// reg.MustRegister(metrics)
+type Metrics struct {
+	requests    *prometheus.CounterVec   // micro, host, cmdid
+	errors      *prometheus.CounterVec   // micro, host, cmdid, class
+	latency     *prometheus.HistogramVec // micro, host, cmdid
+	inflight    *prometheus.GaugeVec     // micro, host
+	hosts       *prometheus.GaugeVec     // micro
+	transitions *prometheus.CounterVec   // micro, host, state
+}
+
+// NewMetrics create metrics, buckets: latency histogram buckets in seconds, nil for the default.
+func NewMetrics(namespace string, buckets []float64) *Metrics {
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	m := new(Metrics)
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "requests_total",
+		Help:      "Requests to micro service hosts, rejected ones included.",
+	}, []string{"micro", "host", "cmdid"})
+	m.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "errors_total",
+		Help:      "Failed requests by error class.",
+	}, []string{"micro", "host", "cmdid", "class"})
+	m.latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "request_duration_seconds",
+		Help:      "Request latency.",
+		Buckets:   buckets,
+	}, []string{"micro", "host", "cmdid"})
+	m.inflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "inflight_requests",
+		Help:      "Requests in flight.",
+	}, []string{"micro", "host"})
+	m.hosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "hosts",
+		Help:      "Hosts in the pool.",
+	}, []string{"micro"})
+	m.transitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pprpcpool",
+		Name:      "conn_state_transitions_total",
+		Help:      "Connection state transitions by the new state.",
+	}, []string{"micro", "host", "state"})
+	return m
+}
+
+// Describe implement prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.errors.Describe(ch)
+	m.latency.Describe(ch)
+	m.inflight.Describe(ch)
+	m.hosts.Describe(ch)
+	m.transitions.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.errors.Collect(ch)
+	m.latency.Collect(ch)
+	m.inflight.Collect(ch)
+	m.hosts.Collect(ch)
+	m.transitions.Collect(ch)
+}
+
+func (m *Metrics) begin(micro, host string) {
+	m.inflight.WithLabelValues(micro, host).Inc()
+}
+
+func (m *Metrics) done(micro, host string, cmdid uint64, d time.Duration, err error) {
+	cmd := strconv.FormatUint(cmdid, 10)
+	m.inflight.WithLabelValues(micro, host).Dec()
+	m.requests.WithLabelValues(micro, host, cmd).Inc()
+	m.latency.WithLabelValues(micro, host, cmd).Observe(d.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(micro, host, cmd, ErrorClass(err)).Inc()
+	}
+}
+
+// reject record a call rejected before it is sent, no latency.
+func (m *Metrics) reject(micro, host string, cmdid uint64, err error) {
+	cmd := strconv.FormatUint(cmdid, 10)
+	m.requests.WithLabelValues(micro, host, cmd).Inc()
+	m.errors.WithLabelValues(micro, host, cmd, ErrorClass(err)).Inc()
+}
+
+func (m *Metrics) transition(micro, host string, state int32) {
+	m.transitions.WithLabelValues(micro, host, stateName(state)).Inc()
+}
+
+func (m *Metrics) setHosts(micro string, n int) {
+	m.hosts.WithLabelValues(micro).Set(float64(n))
+}
+
+// delHost delete all series of the host.
+func (m *Metrics) delHost(micro, host string) {
+	labels := prometheus.Labels{"micro": micro, "host": host}
+	m.requests.DeletePartialMatch(labels)
+	m.errors.DeletePartialMatch(labels)
+	m.latency.DeletePartialMatch(labels)
+	m.inflight.DeletePartialMatch(labels)
+	m.transitions.DeletePartialMatch(labels)
+}
+
+// ErrorClass classify an invoke error for metrics
+func ErrorClass(err error) string {
+	switch {
+	case IsCircuitOpen(err):
+		return ErrClassCircuitOpen
+	case IsOverload(err):
+		return ErrClassOverload
+	case errors.Is(err, context.Canceled):
+		return ErrClassCanceled
+	case isTimeout(err):
+		return ErrClassTimeout
+	}
+	return ErrClassOther
+}
+
+func stateName(state int32) string {
+	switch state {
+	case ConnConnecting:
+		return "connecting"
+	case ConnReady:
+		return "ready"
+	case ConnReconnecting:
+		return "reconnecting"
+	case ConnShutdown:
+		return "shutdown"
+	}
+	return "unknown"
+}
+
+// SetMetrics record pool metrics, nil to disable.
+func (r *RPCCliPool) SetMetrics(m *Metrics) {
+	r.metrics.Store(&metricsHolder{m})
+	if m != nil {
+		m.setHosts(r.micro, len(r.allHosts()))
+	}
+}
+
+type metricsHolder struct {
+	m *Metrics
+}
+
+func (r *RPCCliPool) getMetrics() *Metrics {
+	h, _ := r.metrics.Load().(*metricsHolder)
+	if h == nil {
+		return nil
+	}
+	return h.m
+}
+
+// SetMetrics record metrics of all micro services.
+func (m *MicroClientConn) SetMetrics(mt *Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = mt
+	for _, v := range m.list() {
+		v.SetMetrics(mt)
+	}
+}
@@ -0,0 +1,139 @@
+package pprpcpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pprpc/core/packets"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsCircuitOpen(t *testing.T) {
+	r := newTestPool(t, 1)
+	mt := NewMetrics("test", nil)
+	r.SetMetrics(mt)
+	r.SetInterceptors(nopInterceptor)
+	r.SetBreaker(BreakerConf{})
+	info := r.allHosts()[0]
+
+	b := info.getBreaker()
+	b.mu.Lock()
+	b.trip()
+	b.mu.Unlock()
+	if _, _, err := r.invoke(context.Background(), info, 7, nil); IsCircuitOpen(err) == false {
+		t.Fatalf("invoke() = %v, want circuit open", err)
+	}
+	if v := testutil.ToFloat64(mt.errors.WithLabelValues("", info.urladdr, "7", ErrClassCircuitOpen)); v != 1 {
+		t.Fatalf("circuit_open errors = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(mt.requests.WithLabelValues("", info.urladdr, "7")); v != 1 {
+		t.Fatalf("requests = %v, want 1", v)
+	}
+}
+
+func TestMetricsOverload(t *testing.T) {
+	r := newTestPool(t, 1)
+	mt := NewMetrics("test", nil)
+	r.SetMetrics(mt)
+	r.SetInterceptors(nopInterceptor)
+	if err := r.SetConcurrencyLimit(ConcurrencyLimit{InitialLimit: 1, MaxLimit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	info := r.allHosts()[0]
+
+	// hold the only slot
+	lim := info.getLimiter()
+	if err := lim.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.invoke(context.Background(), info, 7, nil); IsOverload(err) == false {
+		t.Fatalf("invoke() = %v, want overload", err)
+	}
+	if err := r.invokeAsync(context.Background(), info, 7, nil); IsOverload(err) == false {
+		t.Fatalf("invokeAsync() = %v, want overload", err)
+	}
+	lim.release(0, nil, false)
+	if v := testutil.ToFloat64(mt.errors.WithLabelValues("", info.urladdr, "7", ErrClassOverload)); v != 2 {
+		t.Fatalf("overload errors = %v, want 2", v)
+	}
+	if v := testutil.ToFloat64(mt.requests.WithLabelValues("", info.urladdr, "7")); v != 2 {
+		t.Fatalf("requests = %v, want 2", v)
+	}
+}
+
+// hostSeries number of series of the host
+func hostSeries(t *testing.T, mt *Metrics, host string) (n int) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(mt)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "host" && l.GetValue() == host {
+					n++
+				}
+			}
+		}
+	}
+	return
+}
+
+func TestMetricsDelHost(t *testing.T) {
+	r := newTestPool(t, 2)
+	mt := NewMetrics("test", nil)
+	r.SetMetrics(mt)
+	r.SetInterceptors(func(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (*packets.CmdPacket, interface{}, error) {
+		return nil, nil, context.DeadlineExceeded
+	})
+	for i := 0; i < 4; i++ {
+		r.Invoke(context.Background(), 1, nil)
+	}
+	del, keep := r.allHosts()[0], r.allHosts()[1]
+	if hostSeries(t, mt, del.urladdr) == 0 {
+		t.Fatalf("no series of %s", del.urladdr)
+	}
+
+	// the fake connections are not closed
+	for _, sub := range del.getSubs() {
+		sub.swapConn(nil)
+	}
+	if err := r.DelHost(del.urladdr); err != nil {
+		t.Fatal(err)
+	}
+	// no series left, the shutdown transition included
+	if n := hostSeries(t, mt, del.urladdr); n != 0 {
+		t.Fatalf("%d series of the deleted host", n)
+	}
+	if hostSeries(t, mt, keep.urladdr) == 0 {
+		t.Fatalf("series of %s deleted", keep.urladdr)
+	}
+	if v := testutil.ToFloat64(mt.hosts.WithLabelValues(r.micro)); v != 1 {
+		t.Fatalf("hosts = %v, want 1", v)
+	}
+}
+
+func TestMetricsDrainHost(t *testing.T) {
+	r := newTestPool(t, 1)
+	mt := NewMetrics("test", nil)
+	r.SetMetrics(mt)
+	r.SetInterceptors(nopInterceptor)
+	r.Invoke(context.Background(), 1, nil)
+	info := r.allHosts()[0]
+	for _, sub := range info.getSubs() {
+		sub.swapConn(nil)
+	}
+
+	done, err := r.DrainHost(info.urladdr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n := hostSeries(t, mt, info.urladdr); n != 0 {
+		t.Fatalf("%d series of the drained host", n)
+	}
+}
@@ -3,6 +3,9 @@ package pprpcpool
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pprpc/util/cache"
 	"xcthings.com/micro/svc"
@@ -14,104 +17,397 @@ import (
 type ClientPool struct {
 	Name string
 	*RPCCliPool
+	breaker      *CircuitBreaker
+	interceptors []Interceptor
 }
 
-// MicroClientConn micro service conn
+// MicroClientConn micro service conn, safe for concurrent use.
 type MicroClientConn struct {
-	Micros   []ClientPool
+	mu       sync.RWMutex
+	micros   map[string]*ClientPool // ClientPool is replaced, not modified
 	service  *pprpc.Service
 	regCache *cache.Cache
+	regMu    sync.Mutex
+	regKeys  map[string]map[string]bool // micro: register keys in regCache
+	tlsConf  *TLSConf
+	metrics  *Metrics
+	locality *LocalityConf
+
+	drainTimeout time.Duration
+
+	interceptors []Interceptor
+
+	resMu  sync.RWMutex
+	resIdx resIndex
+
+	rateMu      sync.Mutex
+	rateLimits  atomic.Value // map[cmdKey]*tokenBucket
+	rateWatcher *svc.Watcher
+
+	coalesceMu sync.Mutex
+	coalesce   atomic.Value // map[cmdKey]CoalesceKeyFunc
+	flight     flightGroup
+
+	watchMu     sync.Mutex
+	watcher     *svc.Watcher
+	watchCancel context.CancelFunc
+	watched     map[string]bool // keys added by Watch
 }
 
 // NewMicroClientConn new micro service client connection.
 func NewMicroClientConn(s *pprpc.Service) (mcc *MicroClientConn) {
 	mcc = new(MicroClientConn)
+	mcc.micros = make(map[string]*ClientPool)
 	mcc.regCache = cache.NewCache(10000)
+	mcc.drainTimeout = DefaultDrainTimeout
 	mcc.service = s
 	return
 }
 
-// AddMicro add micro service pool
+// AddMicro add micro service pool, its hosts are added at once if watching.
 func (m *MicroClientConn) AddMicro(ms string) (err error) {
+	err = m.addMicro(ms)
+	if err != nil {
+		return
+	}
+	m.watchMicro()
+	return
+}
+
+func (m *MicroClientConn) addMicro(ms string) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.micros[ms]; ok {
+		err = fmt.Errorf("microservice already exists: %s", ms)
+		return
+	}
+
 	cliPool := NewRPCCliPool()
 	cliPool.Service = m.service
+	if m.tlsConf != nil {
+		err = cliPool.SetTLS(*m.tlsConf)
+		if err != nil {
+			return
+		}
+	}
+
+	cliPool.micro = ms
+	if m.metrics != nil {
+		cliPool.SetMetrics(m.metrics)
+	}
+	if m.locality != nil {
+		cliPool.SetLocality(*m.locality)
+	}
 
-	var cp ClientPool
+	cp := new(ClientPool)
 	cp.Name = ms
 	cp.RPCCliPool = cliPool
-	m.Micros = append(m.Micros, cp)
+	m.applyInterceptors(cp)
+	m.micros[ms] = cp
 	return
 }
 
-// Invoke rpc call
-func (m *MicroClientConn) Invoke(ctx context.Context, ms string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
-	for _, v := range m.Micros {
-		if v.Name == ms {
-			pkg, resp, err = v.Invoke(ctx, cmdid, req)
+// RemoveMicro remove micro service pool and close it.
+func (m *MicroClientConn) RemoveMicro(ms string) (err error) {
+	m.mu.Lock()
+	cp, ok := m.micros[ms]
+	delete(m.micros, ms)
+	m.mu.Unlock()
+	if ok == false {
+		err = fmt.Errorf("No microservices found: %s", ms)
+		return
+	}
+	cp.Close()
+	m.purgeRegs(ms)
+	return
+}
+
+// purgeRegs drop the registrations of the removed micro, AddMicro again dials its hosts.
+func (m *MicroClientConn) purgeRegs(ms string) {
+	m.regMu.Lock()
+	keys := m.regKeys[ms]
+	delete(m.regKeys, ms)
+	m.regMu.Unlock()
+
+	for key := range keys {
+		if c, e := m.regCache.Get(key); e == nil {
+			vrs := c.(regHost).vrs
+			m.regCache.Del(key)
+			m.indexRes(key, &vrs, nil)
+		}
+	}
+
+	m.watchMu.Lock()
+	for key := range keys {
+		delete(m.watched, key)
+	}
+	m.watchMu.Unlock()
+}
+
+// Micro return the pool of the micro service, nil if not found.
+func (m *MicroClientConn) Micro(ms string) *ClientPool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.micros[ms]
+}
+
+// MicroNames return names of all micro services.
+func (m *MicroClientConn) MicroNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.micros))
+	for k := range m.micros {
+		names = append(names, k)
+	}
+	return names
+}
+
+func (m *MicroClientConn) getMicro(ms string) (cp *ClientPool, err error) {
+	m.mu.RLock()
+	cp = m.micros[ms]
+	m.mu.RUnlock()
+	if cp == nil {
+		err = fmt.Errorf("No microservices found: %s", ms)
+	}
+	return
+}
+
+// updateMicro replace the pool entry by a modified copy.
+func (m *MicroClientConn) updateMicro(ms string, fn func(cp *ClientPool)) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.micros[ms]
+	if ok == false {
+		err = fmt.Errorf("No microservices found: %s", ms)
+		return
+	}
+	cp := new(ClientPool)
+	*cp = *old
+	fn(cp)
+	m.micros[ms] = cp
+	return
+}
+
+// list must hold m.mu
+func (m *MicroClientConn) list() []*ClientPool {
+	cps := make([]*ClientPool, 0, len(m.micros))
+	for _, v := range m.micros {
+		cps = append(cps, v)
+	}
+	return cps
+}
+
+// SetTLS connect micro services by tls:// listen, call it before AddHost,
+// error if any micro service has hosts.
+func (m *MicroClientConn) SetTLS(conf TLSConf) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.list() {
+		if n := len(v.allHosts()); n > 0 {
+			err = fmt.Errorf("SetTLS, error: %s has %d hosts", v.Name, n)
 			return
 		}
 	}
-	err = fmt.Errorf("No microservices found: %s", ms)
+	for _, v := range m.list() {
+		err = v.SetTLS(conf)
+		if err != nil {
+			return
+		}
+	}
+	m.tlsConf = &conf
+	return
+}
+
+// regHost register value of a host and the url it is dialed by
+type regHost struct {
+	vrs svc.ValueRegService
+	url string
+}
+
+// getURL tls:// url if SetTLS, else tcp:// url
+func (m *MicroClientConn) getURL(vrs svc.ValueRegService) (string, error) {
+	m.mu.RLock()
+	useTLS := m.tlsConf != nil
+	m.mu.RUnlock()
+	if useTLS {
+		return svc.GetTLSURL(vrs)
+	}
+	return svc.GetTCPURL(vrs)
+}
+
+// Invoke rpc call, identical in-flight calls are coalesced if SetCoalesce.
+func (m *MicroClientConn) Invoke(ctx context.Context, ms string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if key, ok := m.coalesceKey(ms, cmdid, req); ok {
+		return m.flight.do(ctx, key, func(ctx context.Context) (*packets.CmdPacket, interface{}, error) {
+			return m.invoke(ctx, v, ms, cmdid, req)
+		})
+	}
+	return m.invoke(ctx, v, ms, cmdid, req)
+}
+
+func (m *MicroClientConn) invoke(ctx context.Context, v *ClientPool, ms string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	if err = v.allow(); err != nil {
+		return
+	}
+	pkg, resp, err = v.Invoke(ctx, cmdid, req)
+	v.done(err)
 	return
 }
 
 // InvokeServerID call invoke by server id
 func (m *MicroClientConn) InvokeServerID(ctx context.Context, ms, serverID string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
-	for _, v := range m.Micros {
-		if v.Name == ms {
-			pkg, resp, err = v.InvokeByServerID(ctx, serverID, cmdid, req)
-			return
-		}
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
 	}
-	err = fmt.Errorf("No microservices found: %s", ms)
+	if err = v.allow(); err != nil {
+		return
+	}
+	pkg, resp, err = v.InvokeByServerID(ctx, serverID, cmdid, req)
+	v.done(err)
+	return
+}
+
+// InvokeByKey call invoke by consistent hash key
+func (m *MicroClientConn) InvokeByKey(ctx context.Context, ms, key string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	if err = v.allow(); err != nil {
+		return
+	}
+	pkg, resp, err = v.RPCCliPool.InvokeByKey(ctx, key, cmdid, req)
+	v.done(err)
+	return
+}
+
+// InvokeAsyncByKey call invoke async by consistent hash key
+func (m *MicroClientConn) InvokeAsyncByKey(ctx context.Context, ms, key string, cmdid uint64, req interface{}) (err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	err = v.RPCCliPool.InvokeAsyncByKey(ctx, key, cmdid, req)
 	return
 }
 
 // AddHost add micro service host
 func (m *MicroClientConn) AddHost(key string, vrs svc.ValueRegService) (err error) {
-	for _, v := range m.Micros {
-		if v.Name == vrs.Name {
-			url, e := svc.GetTCPURL(vrs)
-			if e != nil {
-				err = fmt.Errorf("svc.GetTCPURL(), %s(%v)", err, vrs)
-				return
-			}
-			err = v.AddHost(url)
-			if err != nil {
-				err = fmt.Errorf("microClientInit, AddHost(%s), error: %s", url, err)
-			} else {
-				m.regCache.AddORUpdate(key, vrs)
-			}
+	v, e := m.getMicro(vrs.Name)
+	if e != nil {
+		return
+	}
+	url, e := m.getURL(vrs)
+	if e != nil {
+		err = fmt.Errorf("m.getURL(), %s(%v)", e, vrs)
+		return
+	}
+	// value updated(eg: load), keep the connection
+	var oldVrs *svc.ValueRegService
+	if c, e := m.regCache.Get(key); e == nil {
+		old := c.(regHost)
+		oldVrs = &old.vrs
+		if old.url == url && v.hasHost(url) {
+			m.updateHost(v.RPCCliPool, key, url, oldVrs, vrs)
 			return
 		}
+		if old.url != url {
+			// listen changed, drop the old host
+			v.RPCCliPool.DelHost(old.url)
+		}
+	}
+	err = v.AddHost(url)
+	if err != nil {
+		err = fmt.Errorf("microClientInit, AddHost(%s), error: %s", url, err)
+		if oldVrs != nil {
+			m.delReg(key, *oldVrs)
+		}
+	} else {
+		m.updateHost(v.RPCCliPool, key, url, oldVrs, vrs)
 	}
 	return
 }
 
-// DelHost del micro service host.
+// DelHost del micro service host, drain it by the drain timeout.
 func (m *MicroClientConn) DelHost(key string) (err error) {
-	var url string
-	v, e := m.regCache.Get(key)
+	c, e := m.regCache.Get(key)
 	if e != nil {
 		err = fmt.Errorf("g.RegCache.Get(%s), %s", key, e)
 		return
 	}
-	vrs := v.(svc.ValueRegService)
-
-	for _, v := range m.Micros {
-		if v.Name == vrs.Name {
-			url, err = svc.GetTCPURL(vrs)
-			if err != nil {
-				err = fmt.Errorf("svc.GetTCPURL(), %s(%v)", err, vrs)
-			}
-			err = v.RPCCliPool.DelHost(url)
-			if err != nil {
-				err = fmt.Errorf("DelHost(%s), error: %s", url, err)
-			} else {
-				m.regCache.Del(key)
-			}
-			return
-		}
+	reg := c.(regHost)
+	vrs, url := reg.vrs, reg.url
+
+	v, e := m.getMicro(vrs.Name)
+	if e != nil {
+		m.delReg(key, vrs)
+		return
+	}
+	if d := m.getDrainTimeout(); d > 0 {
+		_, err = v.RPCCliPool.DrainHost(url, d)
+	} else {
+		err = v.RPCCliPool.DelHost(url)
+	}
+	// not in the pool any more, drop the registration too
+	if err == nil || v.hasHost(url) == false {
+		m.delReg(key, vrs)
+	}
+	if err != nil {
+		err = fmt.Errorf("DelHost(%s), error: %s", url, err)
 	}
 	return
 }
+
+// updateHost record the register value of a connected host.
+func (m *MicroClientConn) updateHost(p *RPCCliPool, key, url string, old *svc.ValueRegService, vrs svc.ValueRegService) {
+	m.regCache.AddORUpdate(key, regHost{vrs, url})
+	m.regMu.Lock()
+	if old != nil && old.Name != vrs.Name {
+		delete(m.regKeys[old.Name], key)
+	}
+	if m.regKeys == nil {
+		m.regKeys = make(map[string]map[string]bool)
+	}
+	if m.regKeys[vrs.Name] == nil {
+		m.regKeys[vrs.Name] = make(map[string]bool)
+	}
+	m.regKeys[vrs.Name][key] = true
+	m.regMu.Unlock()
+	m.indexRes(key, old, &vrs)
+	m.updateLoad(p, url, vrs)
+	p.SetHostLocality(url, Locality{Region: vrs.Region, Zone: vrs.Zone})
+	p.SetHostResSrv(url, vrs.ResSrv)
+}
+
+// delReg drop the registration of key.
+func (m *MicroClientConn) delReg(key string, vrs svc.ValueRegService) {
+	m.regCache.Del(key)
+	m.regMu.Lock()
+	delete(m.regKeys[vrs.Name], key)
+	m.regMu.Unlock()
+	m.indexRes(key, &vrs, nil)
+}
+
+func (m *MicroClientConn) updateLoad(p *RPCCliPool, url string, vrs svc.ValueRegService) {
+	if vrs.Load == nil {
+		return
+	}
+	p.UpdateLoad(url, vrs.Load.CPU, vrs.Load.Mem, vrs.Load.Sys)
+}
@@ -0,0 +1,94 @@
+package pprpcpool
+
+import (
+	"testing"
+
+	"github.com/pprpc/core"
+	"xcthings.com/micro/svc"
+)
+
+func TestRemoveMicroReAdd(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	// the host is kept and redialed, no connection to close
+	setDialFail(t)
+	key := "/register/cn/user/10.0.6.1"
+	vrs := svc.ValueRegService{Name: "user", LanIP: "10.0.6.1", ResSrv: []int{3}, Listen: []svc.LisConf{{URI: "tcp://0.0.0.0:6061"}}}
+	m.watched = map[string]bool{key: true}
+
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddHost(key, vrs); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.regCache.Get(key); err == nil {
+		t.Fatalf("%s registered after RemoveMicro", key)
+	}
+	if n := len(m.ListByRes(3)); n != 0 {
+		t.Fatalf("ListByRes() = %d instances after RemoveMicro", n)
+	}
+	if m.watched[key] {
+		t.Fatalf("%s watched after RemoveMicro", key)
+	}
+
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	if err := m.AddHost(key, vrs); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(v.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d after AddMicro again, want 1", n)
+	}
+	if n := len(m.ListByRes(3)); n != 1 {
+		t.Fatalf("ListByRes() = %d instances, want 1", n)
+	}
+	if err := m.DelHost(key); err != nil {
+		t.Fatalf("DelHost(), %s", err)
+	}
+	if n := len(v.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after DelHost, want 0", n)
+	}
+}
+
+// the registration is dropped with the host not in the pool
+func TestAddHostRegisteredNotInPool(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	setDialFail(t)
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	key := "/register/cn/user/10.0.6.2"
+	vrs := svc.ValueRegService{Name: "user", LanIP: "10.0.6.2", Listen: []svc.LisConf{{URI: "tcp://0.0.0.0:6061"}}}
+	if err := m.AddHost(key, vrs); err != nil {
+		t.Fatal(err)
+	}
+
+	// removed from the pool behind the registration
+	url := regURL(t, m, key)
+	if err := v.RPCCliPool.DelHost(url); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddHost(key, vrs); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(v.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d, the registered host was not dialed again", n)
+	}
+}
+
+// regURL the url key is dialed by
+func regURL(t *testing.T, m *MicroClientConn, key string) string {
+	c, err := m.regCache.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(regHost).url
+}
@@ -0,0 +1,242 @@
+package pprpcpool
+
+// 被动异常检测, 按 Invoke 结果剔除异常节点
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/util/logs"
+)
+
+// OutlierDetection passive outlier detection config, a zero value disable the check.
+type OutlierDetection struct {
+	Interval           time.Duration // analysis interval, default 10s
+	ConsecutiveErrors  int           // eject after N consecutive errors
+	TimeoutRatio       float64       // eject if timeouts/requests in an interval >= it, 0-1
+	LatencyFactor      float64       // eject if mean latency > LatencyFactor * median of peers
+	MinRequests        int           // min requests in an interval to check ratio/latency, default 10
+	BaseEjectionTime   time.Duration // eject time = BaseEjectionTime * times ejected, default 30s
+	MaxEjectionTime    time.Duration // default 300s
+	MaxEjectionPercent int           // max percent of hosts ejected at once, at least one host, default 10
+}
+
+// outlierStats per host invoke results
+type outlierStats struct {
+	mu          sync.Mutex
+	consecutive int
+	requests    int
+	timeouts    int
+	latency     time.Duration
+	ejections   int
+}
+
+func (c *ClientConnInfo) ejected(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&c.ejectedUntil)
+}
+
+// SetOutlierDetection enable passive outlier detection, replace the running one.
+func (r *RPCCliPool) SetOutlierDetection(od OutlierDetection) (err error) {
+	if od.ConsecutiveErrors < 0 || od.TimeoutRatio < 0 || od.TimeoutRatio > 1 || od.LatencyFactor < 0 {
+		err = fmt.Errorf("SetOutlierDetection, error: ConsecutiveErrors/TimeoutRatio/LatencyFactor out of range")
+		return
+	}
+	if od.ConsecutiveErrors == 0 && od.TimeoutRatio == 0 && od.LatencyFactor == 0 {
+		err = fmt.Errorf("SetOutlierDetection, error: no check enabled")
+		return
+	}
+	if od.Interval <= 0 {
+		od.Interval = 10 * time.Second
+	}
+	if od.MinRequests <= 0 {
+		od.MinRequests = 10
+	}
+	if od.BaseEjectionTime <= 0 {
+		od.BaseEjectionTime = 30 * time.Second
+	}
+	if od.MaxEjectionTime < od.BaseEjectionTime {
+		od.MaxEjectionTime = 10 * od.BaseEjectionTime
+	}
+	if od.MaxEjectionPercent <= 0 || od.MaxEjectionPercent > 100 {
+		od.MaxEjectionPercent = 10
+	}
+
+	r.StopOutlierDetection()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.mu.Lock()
+	r.odCancel = cancel
+	r.mu.Unlock()
+	r.outlier.Store(&od)
+
+	go r.outlierDetect(ctx, &od)
+	return
+}
+
+// StopOutlierDetection stop outlier detection, ejected hosts are readmitted.
+func (r *RPCCliPool) StopOutlierDetection() {
+	r.mu.Lock()
+	cancel := r.odCancel
+	r.odCancel = nil
+	hosts := r.allHosts()
+	r.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	r.outlier.Store((*OutlierDetection)(nil))
+	for _, h := range hosts {
+		atomic.StoreInt64(&h.ejectedUntil, 0)
+	}
+}
+
+func (r *RPCCliPool) getOutlier() *OutlierDetection {
+	od, _ := r.outlier.Load().(*OutlierDetection)
+	return od
+}
+
+// observe record an invoke result.
+func (r *RPCCliPool) observe(h *ClientConnInfo, latency time.Duration, err error) {
+	od := r.getOutlier()
+	if od == nil {
+		return
+	}
+	// canceled by the caller or a hedge winner, not a host failure
+	if err != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+	h.od.mu.Lock()
+	h.od.requests++
+	h.od.latency += latency
+	if err == nil {
+		h.od.consecutive = 0
+		h.od.mu.Unlock()
+		return
+	}
+	h.od.consecutive++
+	if isTimeout(err) {
+		h.od.timeouts++
+	}
+	n := h.od.consecutive
+	h.od.mu.Unlock()
+
+	if od.ConsecutiveErrors > 0 && n >= od.ConsecutiveErrors {
+		r.eject(od, h, fmt.Sprintf("%d consecutive errors, %s", n, err))
+	}
+}
+
+type outlierSample struct {
+	h        *ClientConnInfo
+	requests int
+	timeouts int
+	mean     time.Duration
+}
+
+func (r *RPCCliPool) outlierDetect(ctx context.Context, od *OutlierDetection) {
+	t := time.NewTicker(od.Interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		hosts := r.allHosts()
+
+		var samples []outlierSample
+		var means []time.Duration
+		now := time.Now()
+		for _, h := range hosts {
+			h.od.mu.Lock()
+			s := outlierSample{h: h, requests: h.od.requests, timeouts: h.od.timeouts}
+			if s.requests > 0 {
+				s.mean = h.od.latency / time.Duration(s.requests)
+			}
+			h.od.requests, h.od.timeouts, h.od.latency = 0, 0, 0
+			h.od.mu.Unlock()
+
+			if s.requests < od.MinRequests {
+				continue
+			}
+			samples = append(samples, s)
+			means = append(means, s.mean)
+		}
+
+		var median time.Duration
+		if len(means) >= 3 {
+			sort.Slice(means, func(i, j int) bool { return means[i] < means[j] })
+			median = means[len(means)/2]
+		}
+
+		for _, s := range samples {
+			if od.TimeoutRatio > 0 && float64(s.timeouts)/float64(s.requests) >= od.TimeoutRatio {
+				r.eject(od, s.h, fmt.Sprintf("timeout ratio %d/%d", s.timeouts, s.requests))
+				continue
+			}
+			if od.LatencyFactor > 0 && median > 0 && float64(s.mean) > od.LatencyFactor*float64(median) {
+				r.eject(od, s.h, fmt.Sprintf("mean latency %s, peers median %s", s.mean, median))
+				continue
+			}
+			// a good interval, reduce the ejection time of the next one
+			if s.h.ejected(now) == false {
+				s.h.od.mu.Lock()
+				if s.h.od.ejections > 0 {
+					s.h.od.ejections--
+				}
+				s.h.od.mu.Unlock()
+			}
+		}
+	}
+}
+
+// eject take the host out of selection, unless too many hosts are ejected.
+func (r *RPCCliPool) eject(od *OutlierDetection, h *ClientConnInfo, reason string) {
+	hosts := r.allHosts()
+
+	now := time.Now()
+	if h.ejected(now) {
+		return
+	}
+	n := 0
+	for _, v := range hosts {
+		if v.ejected(now) {
+			n++
+		}
+	}
+	if n > 0 && (n+1)*100 > len(hosts)*od.MaxEjectionPercent {
+		logs.Logger.Warnf("outlier %s not ejected, %d/%d hosts ejected, %s.", h.urladdr, n, len(hosts), reason)
+		return
+	}
+
+	h.od.mu.Lock()
+	h.od.ejections++
+	d := od.BaseEjectionTime * time.Duration(h.od.ejections)
+	if d > od.MaxEjectionTime {
+		d = od.MaxEjectionTime
+	}
+	h.od.consecutive = 0
+	h.od.mu.Unlock()
+
+	atomic.StoreInt64(&h.ejectedUntil, now.Add(d).UnixNano())
+	logs.Logger.Warnf("outlier %s ejected %s, %s.", h.urladdr, d, reason)
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "timeout")
+}
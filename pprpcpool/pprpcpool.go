@@ -2,6 +2,7 @@ package pprpcpool
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/url"
 	"sync"
@@ -11,7 +12,6 @@ import (
 	"github.com/pprpc/util/logs"
 	"github.com/pprpc/core"
 	"github.com/pprpc/core/packets"
-	"github.com/pprpc/core/pptcp"
 	"github.com/pprpc/core/sess"
 )
 
@@ -21,19 +21,92 @@ type ClientConnInfo struct {
 	Mem []float32 // total, free, 比例
 	Sys []float32 // 5, 10, 15
 
-	urladdr string
-	url     *url.URL
-	cli     *pprpc.TCPCliConn
+	weight int32 // 权重, weighted round-robin
+
+	urladdr      string
+	url          *url.URL
+	subs         []*subConn
+	subsMu       sync.RWMutex
+	conns        HostConns
+	growing      int32
+	state        int32
+	ready        int32 // connections in ConnReady, kept by the supervisors
+	done         chan struct{}
+	doneOnce     sync.Once
+	inflight     int32
+	draining     int32
+	unhealthy    int32
+	ejectedUntil int64
+	od           outlierStats
+	breaker      atomic.Value // *CircuitBreaker
+	limiter      atomic.Value // *limiter
+	resSrv       atomic.Value // []int
+	locality     atomic.Value // Locality
+	loadMu       sync.RWMutex
+	loadTime     time.Time
+}
+
+// Addr host url
+func (c *ClientConnInfo) Addr() string {
+	return c.urladdr
+}
+
+// Weight host weight, used by weighted round-robin
+func (c *ClientConnInfo) Weight() int {
+	return int(atomic.LoadInt32(&c.weight))
+}
+
+// Inflight number of requests in flight
+func (c *ClientConnInfo) Inflight() int32 {
+	return atomic.LoadInt32(&c.inflight)
+}
+
+// connected any connection ready at the last supervisor check, lock free
+func (c *ClientConnInfo) connected() bool {
+	return atomic.LoadInt32(&c.ready) > 0
+}
+
+// available connected and not ejected, lock free
+func (c *ClientConnInfo) available() bool {
+	if b := c.getBreaker(); b != nil && b.ready() == false {
+		return false
+	}
+	if c.Draining() {
+		return false
+	}
+	return c.healthy() && c.ejected(time.Now()) == false && c.connected()
 }
 
 // RPCCliPool PPRPC conn pool
 type RPCCliPool struct {
 	//clis *sync.Map // ClientConnInfo
+	ctx            context.Context
+	ctxCancel      context.CancelFunc
 	clis           *sess.Sessions
 	Service        *pprpc.Service
 	totalReq       uint32
 	addrs          []string
 	mu             sync.Mutex
+	lb             atomic.Value // *lbHolder
+	snap           atomic.Value // *hostSnapshot
+	hashReplicas   int
+	reconnect      ReconnectConf
+	hostConns      HostConns
+	tlsConfig      *tls.Config
+	micro          string
+	interceptors   atomic.Value // []Interceptor
+	metrics        atomic.Value // *metricsHolder
+	hcCancel       context.CancelFunc
+	lpCancel       context.CancelFunc
+	odCancel       context.CancelFunc
+	outlier        atomic.Value // *OutlierDetection
+	breakerConf    *BreakerConf
+	limitConf      *ConcurrencyLimit
+	retryPolicies  atomic.Value // map[uint64]RetryPolicy
+	retryBudget    atomic.Value // *retryBudget
+	hedgePolicies  atomic.Value // map[uint64]HedgePolicy
+	hedgeStates    sync.Map     // cmdid: *hedgeState
+	locality       atomic.Value // *LocalityConf
 	WriteTimeoutMs int
 }
 
@@ -42,11 +115,29 @@ func NewRPCCliPool() *RPCCliPool {
 	_t := new(RPCCliPool)
 	_t.clis = sess.NewSessions(8000)
 	_t.mu = sync.Mutex{}
+	_t.lb.Store(&lbHolder{NewRoundRobinBalancer()})
+	_t.hashReplicas = DefaultHashReplicas
+	_t.reconnect = DefaultReconnectConf
+	_t.hostConns = HostConns{Min: 1, Max: 1}
 	_t.WriteTimeoutMs = 3000
+	_t.ctx, _t.ctxCancel = context.WithCancel(context.Background())
 
 	return _t
 }
 
+// Close stop background tasks and close all hosts
+func (r *RPCCliPool) Close() {
+	r.ctxCancel()
+
+	r.mu.Lock()
+	addrs := make([]string, len(r.addrs))
+	copy(addrs, r.addrs)
+	r.mu.Unlock()
+	for _, addr := range addrs {
+		r.DelHost(addr)
+	}
+}
+
 // SetWriteTimeout ms
 func (r *RPCCliPool) SetWriteTimeout(ms int) (err error) {
 	if r.Service == nil {
@@ -61,7 +152,41 @@ func (r *RPCCliPool) SetWriteTimeout(ms int) (err error) {
 	return
 }
 
-// AddHost .
+// SetBalancer set load balancing strategy
+func (r *RPCCliPool) SetBalancer(lb Balancer) (err error) {
+	if lb == nil {
+		err = fmt.Errorf("SetBalancer, error: balancer is nil")
+		return
+	}
+	r.lb.Store(&lbHolder{lb})
+	return
+}
+
+// SetHashReplicas set consistent hash virtual nodes per host
+func (r *RPCCliPool) SetHashReplicas(n int) (err error) {
+	if n < 1 || n > 10000 {
+		err = fmt.Errorf("Out of range: 1-10000")
+		return
+	}
+	r.mu.Lock()
+	r.hashReplicas = n
+	r.rebuild()
+	r.mu.Unlock()
+	return
+}
+
+// SetWeight set host weight, used by weighted round-robin
+func (r *RPCCliPool) SetWeight(addr string, weight int) (err error) {
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		err = fmt.Errorf("Load(%s), %s", addr, e)
+		return
+	}
+	atomic.StoreInt32(&v.(*ClientConnInfo).weight, int32(weight))
+	return
+}
+
+// AddHost add the host, a failed dial is not an error, the host is redialed with backoff.
 func (r *RPCCliPool) AddHost(addr string) (err error) {
 	if r.Service == nil {
 		err = fmt.Errorf("AddHost, error: not set Service")
@@ -73,114 +198,300 @@ func (r *RPCCliPool) AddHost(addr string) (err error) {
 		err = fmt.Errorf("url.ParseRequestURI(%s), error: %s", uri, e)
 		return
 	}
-	var err1 error
-	conn, e := pprpc.Dail(u, nil, r.Service, 5*time.Second, nil)
-	if e != nil {
-		err1 = fmt.Errorf("pprpc.Dail(), error: %s", e)
+	r.mu.Lock()
+	conns := r.hostConns
+	r.mu.Unlock()
 
+	info := &ClientConnInfo{weight: 1, urladdr: addr, url: u, conns: conns}
+	info.done = make(chan struct{})
+
+	for i := 0; i < conns.Min; i++ {
+		conn, e := r.dial(u)
+		if e != nil {
+			// the host is kept, supervisor redial the failed one
+			logs.Logger.Warnf("AddHost(%s), pprpc.Dail(), %s, reconnecting.", addr, e)
+		}
+		r.addSub(info, conn)
 	}
-	conn.SyncWriteTimeoutMs = r.WriteTimeoutMs
-	err = r.addHost(addr, conn)
-	if err == nil && err1 != nil {
-		err = err1
-	} else if err != nil && err1 != nil {
-		err = fmt.Errorf("%s; r.addHost(addr, conn), %s", err1, err)
-	}
+	err = r.addHost(addr, info)
 
 	return
 }
 
+// dialConn replaced by tests
+var dialConn = func(u *url.URL, tc *tls.Config, s *pprpc.Service) (*pprpc.TCPCliConn, error) {
+	return pprpc.Dail(u, tc, s, 5*time.Second, nil)
+}
+
+func (r *RPCCliPool) dial(u *url.URL) (conn *pprpc.TCPCliConn, err error) {
+	var tc *tls.Config
+	if u.Scheme == "tls" {
+		tc, err = r.getTLSConfig(u.Hostname())
+		if err != nil {
+			return
+		}
+	}
+	conn, err = dialConn(u, tc, r.Service)
+	if conn != nil {
+		conn.SyncWriteTimeoutMs = r.WriteTimeoutMs
+	}
+	return
+}
+
 // DelHost .
 func (r *RPCCliPool) DelHost(addr string) (err error) {
-	v, e := r.clis.Get(addr)
-	if e != nil {
-		err = fmt.Errorf("Load(%s), %s", addr, e)
+	info, err := r.takeHost(addr, false)
+	if err != nil {
 		return
 	}
-	v.(*pprpc.TCPCliConn).Close()
-	r.delHost(addr)
+	info.shutdown()
+	// the series of the host are dropped, no shutdown transition
+	if mt := r.getMetrics(); mt != nil {
+		mt.delHost(r.micro, addr)
+	}
 
 	return
 }
 
 // Invoke .
 func (r *RPCCliPool) Invoke(ctx context.Context, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
-	var cli *pprpc.TCPCliConn
-	cli, err = r.GetCli()
-	if cli == nil || err != nil {
+	if b := r.getRetryBudget(); b != nil {
+		b.request()
+	}
+	if p, ok := r.getHedgePolicy(cmdid); ok {
+		pkg, resp, err = r.invokeHedge(ctx, p, cmdid, req)
+		return
+	}
+	if p, ok := r.getRetryPolicy(cmdid); ok {
+		pkg, resp, err = r.invokeRetry(ctx, p, cmdid, req)
+		return
+	}
+	var info *ClientConnInfo
+	info, err = r.lbs(nil)
+	if info == nil || err != nil {
 		return
 	}
 	// debugs
-	logs.Logger.Debugf("addrs: %v, client info: %s.", r.addrs, cli.String())
+	logs.Logger.Debugf("hosts: %d, client info: %s.", len(r.getSnapshot().hosts), info.urladdr)
 
-	pkg, resp, err = cli.Invoke(ctx, cmdid, req)
+	pkg, resp, err = r.invoke(ctx, info, cmdid, req)
 
 	return
 }
 
 // InvokeAsync .
 func (r *RPCCliPool) InvokeAsync(ctx context.Context, cmdid uint64, req interface{}) (err error) {
-	var cli *pprpc.TCPCliConn
-	cli, err = r.GetCli()
-	if cli == nil || err != nil {
+	var info *ClientConnInfo
+	info, err = r.lbs(nil)
+	if info == nil || err != nil {
 		return
 	}
-	err = cli.InvokeAsync(ctx, cmdid, req)
+	err = r.invokeAsync(ctx, info, cmdid, req)
 
 	return
 }
 
-// lbs .
-func (r *RPCCliPool) lbs() {
-
+func (r *RPCCliPool) invoke(ctx context.Context, info *ClientConnInfo, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	sub := info.pickConn()
+	var cli *pprpc.TCPCliConn
+	if sub != nil {
+		cli = sub.getConn()
+	}
+	if cli == nil {
+		err = fmt.Errorf("Connection is closed: %s", info.urladdr)
+		return
+	}
+	lim := info.getLimiter()
+	if lim != nil {
+		if err = lim.acquire(ctx); err != nil {
+			if mt := r.getMetrics(); mt != nil {
+				mt.reject(r.micro, info.urladdr, cmdid, err)
+			}
+			return
+		}
+	}
+	b := info.getBreaker()
+	if b != nil {
+		if err = b.Allow(); err != nil {
+			if lim != nil {
+				lim.release(0, err, false)
+			}
+			if mt := r.getMetrics(); mt != nil {
+				mt.reject(r.micro, info.urladdr, cmdid, err)
+			}
+			return
+		}
+	}
+	atomic.AddUint32(&r.totalReq, 1)
+	atomic.AddInt32(&info.inflight, 1)
+	atomic.AddInt32(&sub.inflight, 1)
+	r.grow(info, sub)
+	mt := r.getMetrics()
+	if mt != nil {
+		mt.begin(r.micro, info.urladdr)
+	}
+	start := time.Now()
+	pkg, resp, err = r.intercept(ctx, r.newInvokeInfo(ctx, info, cmdid, false), req,
+		func(ctx context.Context, ii *InvokeInfo, req interface{}) (*packets.CmdPacket, interface{}, error) {
+			return cli.Invoke(ctx, ii.CmdID, req)
+		})
+	atomic.AddInt32(&sub.inflight, -1)
+	atomic.AddInt32(&info.inflight, -1)
+	if mt != nil {
+		mt.done(r.micro, info.urladdr, cmdid, time.Since(start), err)
+	}
+	r.observe(info, time.Since(start), err)
+	if lim != nil {
+		lim.release(time.Since(start), err, true)
+	}
+	if b != nil {
+		b.Done(err)
+	}
+	return
 }
 
-// GetTotalReq .
-func (r *RPCCliPool) GetTotalReq() uint32 {
-	return atomic.LoadUint32(&r.totalReq)
+func (r *RPCCliPool) invokeAsync(ctx context.Context, info *ClientConnInfo, cmdid uint64, req interface{}) (err error) {
+	sub := info.pickConn()
+	var cli *pprpc.TCPCliConn
+	if sub != nil {
+		cli = sub.getConn()
+	}
+	if cli == nil {
+		err = fmt.Errorf("Connection is closed: %s", info.urladdr)
+		return
+	}
+	lim := info.getLimiter()
+	if lim != nil {
+		if err = lim.acquire(ctx); err != nil {
+			if mt := r.getMetrics(); mt != nil {
+				mt.reject(r.micro, info.urladdr, cmdid, err)
+			}
+			return
+		}
+	}
+	b := info.getBreaker()
+	if b != nil {
+		if err = b.Allow(); err != nil {
+			if lim != nil {
+				lim.release(0, err, false)
+			}
+			if mt := r.getMetrics(); mt != nil {
+				mt.reject(r.micro, info.urladdr, cmdid, err)
+			}
+			return
+		}
+	}
+	atomic.AddUint32(&r.totalReq, 1)
+	atomic.AddInt32(&info.inflight, 1)
+	atomic.AddInt32(&sub.inflight, 1)
+	mt := r.getMetrics()
+	if mt != nil {
+		mt.begin(r.micro, info.urladdr)
+	}
+	start := time.Now()
+	_, _, err = r.intercept(ctx, r.newInvokeInfo(ctx, info, cmdid, true), req,
+		func(ctx context.Context, ii *InvokeInfo, req interface{}) (*packets.CmdPacket, interface{}, error) {
+			return nil, nil, cli.InvokeAsync(ctx, ii.CmdID, req)
+		})
+	atomic.AddInt32(&sub.inflight, -1)
+	atomic.AddInt32(&info.inflight, -1)
+	if mt != nil {
+		mt.done(r.micro, info.urladdr, cmdid, time.Since(start), err)
+	}
+	if lim != nil {
+		lim.release(time.Since(start), err, false)
+	}
+	if b != nil {
+		b.Done(err)
+	}
+	return
 }
 
-func (r *RPCCliPool) getCli() (cli *pprpc.TCPCliConn, err error) {
-	v := atomic.LoadUint32(&r.totalReq) + 1
-	atomic.StoreUint32(&r.totalReq, v)
+// lbs pick an available host by the pool balancer,
+// hosts in exclude are skipped unless no other host is available.
+func (r *RPCCliPool) lbs(exclude map[*ClientConnInfo]bool) (info *ClientConnInfo, err error) {
+	lb := r.getBalancer()
+	hosts := r.getHosts()
+
+	if len(exclude) > 0 {
+		other := make([]*ClientConnInfo, 0, len(hosts))
+		for _, h := range hosts {
+			if exclude[h] == false {
+				other = append(other, h)
+			}
+		}
+		if len(other) > 0 {
+			hosts = other
+		}
+	}
+
+	hosts = r.preferLocal(r.allHosts(), hosts)
 
-	m := v % uint32(len(r.addrs))
-	key := r.addrs[m]
-	c, e := r.clis.Get(key)
-	if e != nil {
-		err = e
+	if len(hosts) == 0 {
+		err = r.noHostError()
 		return
 	}
-	cli = c.(*pprpc.TCPCliConn)
+	info, err = lb.Pick(hosts)
 	return
 }
 
-// GetCli .
-func (r *RPCCliPool) GetCli() (cli *pprpc.TCPCliConn, err error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// noHostError *CircuitOpenError if the breaker of any host is open.
+func (r *RPCCliPool) noHostError() error {
+	for _, h := range r.allHosts() {
+		if b := h.getBreaker(); b != nil && b.ready() == false && h.connected() {
+			return &CircuitOpenError{Name: h.urladdr}
+		}
+	}
+	return fmt.Errorf("No microservices found")
+}
 
-	len := len(r.addrs)
-	for i := 0; i < len; i++ {
-		cli, err = r.getCli()
-		if err != nil {
+// hasHost addr is in the pool
+func (r *RPCCliPool) hasHost(addr string) bool {
+	_, e := r.clis.Get(addr)
+	return e == nil
+}
+
+// GetTotalReq .
+func (r *RPCCliPool) GetTotalReq() uint32 {
+	return atomic.LoadUint32(&r.totalReq)
+}
+
+// getHosts return available hosts, the snapshot itself if all are available, do not modify it.
+func (r *RPCCliPool) getHosts() (hosts []*ClientConnInfo) {
+	all := r.allHosts()
+	for i, info := range all {
+		if info.available() {
 			continue
 		}
-		s, e := cli.GetState()
-		if e != nil || s != pptcp.StateConnected {
-			err = fmt.Errorf("Connection status is incorrect")
-			continue
-		} else {
-			return
+		hosts = make([]*ClientConnInfo, i, len(all))
+		copy(hosts, all[:i])
+		for _, h := range all[i+1:] {
+			if h.available() {
+				hosts = append(hosts, h)
+			}
 		}
+		return
 	}
-	if cli == nil {
-		err = fmt.Errorf("No microservices found")
+	return all
+}
+
+// allHosts return all hosts, do not modify it.
+func (r *RPCCliPool) allHosts() []*ClientConnInfo {
+	return r.getSnapshot().hosts
+}
+
+// GetCli .
+func (r *RPCCliPool) GetCli() (cli *pprpc.TCPCliConn, err error) {
+	var info *ClientConnInfo
+	info, err = r.lbs(nil)
+	if err != nil {
+		return
 	}
+	cli = info.getConn()
 	return
 }
 
-func (r *RPCCliPool) addHost(addr string, conn *pprpc.TCPCliConn) (err error) {
+func (r *RPCCliPool) addHost(addr string, conn *ClientConnInfo) (err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -193,15 +504,42 @@ func (r *RPCCliPool) addHost(addr string, conn *pprpc.TCPCliConn) (err error) {
 	}
 	if isExist == false {
 		r.addrs = append(r.addrs, addr)
+	} else if old, e := r.clis.Get(addr); e == nil {
+		// replaced, stop the old supervisor
+		old.(*ClientConnInfo).shutdown()
+	}
+	if r.breakerConf != nil {
+		conn.breaker.Store(NewCircuitBreaker(addr, *r.breakerConf))
+	}
+	if r.limitConf != nil {
+		conn.limiter.Store(newLimiter(addr, *r.limitConf))
 	}
 	_, err = r.clis.Push(addr, conn)
+	r.rebuild()
 	return
 }
 
-func (r *RPCCliPool) delHost(addr string) {
+// takeHost lookup, mark draining and remove the host under r.mu,
+// the caller owns the returned host, a host re-added concurrently is kept.
+func (r *RPCCliPool) takeHost(addr string, draining bool) (info *ClientConnInfo, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		err = fmt.Errorf("Load(%s), %s", addr, e)
+		return
+	}
+	info = v.(*ClientConnInfo)
+	if draining {
+		atomic.StoreInt32(&info.draining, 1)
+	}
+	r.delHost(addr)
+	return
+}
+
+// delHost must hold r.mu
+func (r *RPCCliPool) delHost(addr string) {
 	var i int
 	var v string
 	for i, v = range r.addrs {
@@ -215,55 +553,103 @@ func (r *RPCCliPool) delHost(addr string) {
 		}
 	}
 	r.clis.Remove(addr)
+	r.rebuild()
 	return
 }
 
 // InvokeByServerID .
 func (r *RPCCliPool) InvokeByServerID(ctx context.Context, serverID string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
-	var cli *pprpc.TCPCliConn
-	cli, err = r.GetCliByServerID(serverID)
-	if cli == nil || err != nil {
+	var info *ClientConnInfo
+	info, err = r.getByServerID(serverID)
+	if info == nil || err != nil {
 		return
 	}
-	pkg, resp, err = cli.Invoke(ctx, cmdid, req)
+	pkg, resp, err = r.invoke(ctx, info, cmdid, req)
 
 	return
 }
 
 // InvokeAsyncByServerID .
 func (r *RPCCliPool) InvokeAsyncByServerID(ctx context.Context, serverID string, cmdid uint64, req interface{}) (err error) {
-	var cli *pprpc.TCPCliConn
-	cli, err = r.GetCliByServerID(serverID)
-	if cli == nil || err != nil {
+	var info *ClientConnInfo
+	info, err = r.getByServerID(serverID)
+	if info == nil || err != nil {
 		return
 	}
-	err = cli.InvokeAsync(ctx, cmdid, req)
+	err = r.invokeAsync(ctx, info, cmdid, req)
 
 	return
 }
 
-// GetCliByServerID .
-func (r *RPCCliPool) GetCliByServerID(serverID string) (cli *pprpc.TCPCliConn, err error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// InvokeByKey call the host owning key on the consistent hash ring,
+// the next host on the ring is used when the owner is not connected.
+func (r *RPCCliPool) InvokeByKey(ctx context.Context, key string, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	var info *ClientConnInfo
+	info, err = r.getByKey(key)
+	if info == nil || err != nil {
+		return
+	}
+	pkg, resp, err = r.invoke(ctx, info, cmdid, req)
 
-	for _, v := range r.addrs {
-		u, e := url.ParseRequestURI(v)
-		if e != nil {
-			err = fmt.Errorf("url.ParseRequestURI(%s), %s", v, e)
-			return
-		}
-		if serverID == u.Hostname() {
-			c, e := r.clis.Get(v)
-			if e != nil {
-				err = fmt.Errorf("r.clis.Get(%s), %s", v, e)
-				return
-			}
-			cli = c.(*pprpc.TCPCliConn)
-			return
+	return
+}
+
+// InvokeAsyncByKey .
+func (r *RPCCliPool) InvokeAsyncByKey(ctx context.Context, key string, cmdid uint64, req interface{}) (err error) {
+	var info *ClientConnInfo
+	info, err = r.getByKey(key)
+	if info == nil || err != nil {
+		return
+	}
+	err = r.invokeAsync(ctx, info, cmdid, req)
+
+	return
+}
+
+// GetCliByKey .
+func (r *RPCCliPool) GetCliByKey(key string) (cli *pprpc.TCPCliConn, err error) {
+	var info *ClientConnInfo
+	info, err = r.getByKey(key)
+	if err != nil {
+		return
+	}
+	cli = info.getConn()
+	return
+}
+
+func (r *RPCCliPool) getByKey(key string) (info *ClientConnInfo, err error) {
+	snap := r.getSnapshot()
+	snap.ring.walk(key, func(addr string) bool {
+		c := snap.byAddr[addr]
+		if c == nil || c.available() == false {
+			return false
 		}
+		info = c
+		return true
+	})
+	if info == nil {
+		err = fmt.Errorf("No microservices found(key): %s", key)
+	}
+	return
+}
+
+// GetCliByServerID .
+func (r *RPCCliPool) GetCliByServerID(serverID string) (cli *pprpc.TCPCliConn, err error) {
+	var info *ClientConnInfo
+	info, err = r.getByServerID(serverID)
+	if err != nil {
+		return
 	}
+	cli = info.getConn()
 	if cli == nil {
+		err = fmt.Errorf("Connection is closed: %s", info.urladdr)
+	}
+	return
+}
+
+func (r *RPCCliPool) getByServerID(serverID string) (info *ClientConnInfo, err error) {
+	info = r.getSnapshot().byServerID[serverID]
+	if info == nil {
 		err = fmt.Errorf("No microservices found(server_id): %s", serverID)
 	}
 	return
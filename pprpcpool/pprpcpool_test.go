@@ -0,0 +1,249 @@
+package pprpcpool
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pprpc/core"
+	"github.com/pprpc/core/packets"
+)
+
+// fakeConns connections dialed by the tests, connected until removed
+var fakeConns sync.Map
+
+// dialFail 1: the test dial fails
+var dialFail int32
+
+func init() {
+	dialConn = func(u *url.URL, tc *tls.Config, s *pprpc.Service) (*pprpc.TCPCliConn, error) {
+		if atomic.LoadInt32(&dialFail) == 1 {
+			return nil, fmt.Errorf("dial tcp %s: connection refused", u.Host)
+		}
+		c := new(pprpc.TCPCliConn)
+		fakeConns.Store(c, true)
+		return c, nil
+	}
+	connState = func(c *pprpc.TCPCliConn) bool {
+		_, ok := fakeConns.Load(c)
+		return ok
+	}
+}
+
+// setDialFail make the test dial fail until the test ends.
+func setDialFail(tb testing.TB) {
+	atomic.StoreInt32(&dialFail, 1)
+	tb.Cleanup(func() { atomic.StoreInt32(&dialFail, 0) })
+}
+
+// newTestPool pool of n connected fake hosts, the fake connections are never closed.
+func newTestPool(tb testing.TB, n int) *RPCCliPool {
+	r := NewRPCCliPool()
+	r.Service = new(pprpc.Service)
+	tb.Cleanup(r.ctxCancel)
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("tcp://10.0.0.%d:6061", i+1)
+		if err := r.AddHost(addr); err != nil {
+			tb.Fatalf("AddHost(%s), %s", addr, err)
+		}
+	}
+	return r
+}
+
+// nopInterceptor end the chain without calling the connection
+func nopInterceptor(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (*packets.CmdPacket, interface{}, error) {
+	return nil, nil, nil
+}
+
+func TestAddHostDialFailure(t *testing.T) {
+	r := newTestPool(t, 0)
+	setDialFail(t)
+
+	addr := "tcp://10.0.1.1:6061"
+	if err := r.AddHost(addr); err != nil {
+		t.Fatalf("AddHost(%s) = %s, want nil, the host is redialed", addr, err)
+	}
+	if n := len(r.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d, want 1", n)
+	}
+	if _, err := r.GetCli(); err == nil {
+		t.Fatalf("GetCli() picked a host not connected")
+	}
+	if err := r.DelHost(addr); err != nil {
+		t.Fatalf("DelHost(%s), %s", addr, err)
+	}
+	if n := len(r.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after DelHost, want 0", n)
+	}
+}
+
+func TestGetHostsSkipUnavailable(t *testing.T) {
+	r := newTestPool(t, 4)
+	all := r.allHosts()
+	atomic.StoreInt32(&all[1].draining, 1)
+
+	hosts := r.getHosts()
+	if len(hosts) != 3 {
+		t.Fatalf("getHosts() = %d hosts, want 3", len(hosts))
+	}
+	for _, h := range hosts {
+		if h == all[1] {
+			t.Fatalf("getHosts() returned the draining host")
+		}
+	}
+	if len(r.allHosts()) != 4 {
+		t.Fatalf("getHosts() modified the snapshot")
+	}
+}
+
+func TestHostReadyFollowsSupervisor(t *testing.T) {
+	r := NewRPCCliPool()
+	r.Service = new(pprpc.Service)
+	t.Cleanup(r.ctxCancel)
+	if err := r.SetReconnect(ReconnectConf{CheckInterval: 5 * time.Millisecond, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddHost("tcp://10.0.0.1:6061"); err != nil {
+		t.Fatal(err)
+	}
+	info := r.allHosts()[0]
+	if len(r.getHosts()) != 1 {
+		t.Fatalf("connected host not available")
+	}
+
+	// drop the connection, the redial fails
+	setDialFail(t)
+	fakeConns.Delete(info.getSubs()[0].getConn())
+	waitFor(t, func() bool { return len(r.getHosts()) == 0 })
+	if s := info.State(); s != ConnReconnecting {
+		t.Fatalf("state = %d, want reconnecting", s)
+	}
+
+	atomic.StoreInt32(&dialFail, 0)
+	waitFor(t, func() bool { return len(r.getHosts()) == 1 })
+}
+
+func TestBreakerReadyLockFree(t *testing.T) {
+	b := NewCircuitBreaker("h", BreakerConf{OpenTimeout: 20 * time.Millisecond, HalfOpenRequests: 1})
+	b.mu.Lock()
+	b.trip()
+	// ready must not wait for mu
+	if b.ready() {
+		t.Fatalf("ready() with the breaker open")
+	}
+	b.mu.Unlock()
+	waitFor(t, b.ready)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after the open timeout, %s", err)
+	}
+	if b.ready() || b.State() != BreakerHalfOpen {
+		t.Fatalf("ready() with the half-open probe in flight")
+	}
+	b.Done(nil)
+	if b.ready() == false || b.State() != BreakerClosed {
+		t.Fatalf("breaker not closed after the probe succeeded")
+	}
+}
+
+// a host re-added while DrainHost runs is either kept or drained, never leaked
+func TestDrainHostConcurrentAdd(t *testing.T) {
+	base := runtime.NumGoroutine()
+	r := newTestPool(t, 0)
+	setDialFail(t)
+	addr := "tcp://10.0.1.1:6061"
+
+	var wg sync.WaitGroup
+	dones := make(chan (<-chan struct{}), 2000)
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				r.AddHost(addr)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if done, err := r.DrainHost(addr, 0); err == nil {
+					dones <- done
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if done, err := r.DrainHost(addr, 0); err == nil {
+		dones <- done
+	}
+	close(dones)
+	for done := range dones {
+		<-done
+	}
+	if n := len(r.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after the last DrainHost, want 0", n)
+	}
+	// the supervisors of all the hosts stopped
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= base })
+}
+
+// waitFor poll cond up to 2s
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for cond() == false {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in 2s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func benchmarkPool(b *testing.B, n int) *RPCCliPool {
+	r := newTestPool(b, n)
+	r.SetInterceptors(nopInterceptor)
+	b.ReportAllocs()
+	b.ResetTimer()
+	return r
+}
+
+// go test -run - -bench . -cpu 1,2,4,8
+func BenchmarkGetCli(b *testing.B) {
+	r := benchmarkPool(b, 8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := r.GetCli(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetCliBreaker(b *testing.B) {
+	r := benchmarkPool(b, 8)
+	r.SetBreaker(BreakerConf{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := r.GetCli(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	r := benchmarkPool(b, 8)
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := r.Invoke(ctx, 1, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package pprpcpool
+
+// 客户端限流, 令牌桶
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pprpc/util/logs"
+	"xcthings.com/micro/svc"
+)
+
+// RateLimitError call rejected by the client rate limit
+type RateLimitError struct {
+	Micro string
+	CmdID uint64 // 0: limit of the micro
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: %s(%d)", e.Micro, e.CmdID)
+}
+
+// IsRateLimited .
+func IsRateLimited(err error) bool {
+	var e *RateLimitError
+	return errors.As(err, &e)
+}
+
+type rateWaitKey struct{}
+
+// WithRateLimitWait override the Wait of the rate limit config for calls with ctx,
+// true: wait for a token until ctx is done, false: fail fast.
+func WithRateLimitWait(ctx context.Context, wait bool) context.Context {
+	return context.WithValue(ctx, rateWaitKey{}, wait)
+}
+
+// tokenBucket rate tokens per second, up to burst
+type tokenBucket struct {
+	conf svc.RateLimitConf
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(conf svc.RateLimitConf) *tokenBucket {
+	b := new(tokenBucket)
+	b.conf = conf
+	b.tokens = float64(conf.Burst)
+	b.last = time.Now()
+	return b
+}
+
+// take a token, wait: reserve it and sleep until it is due.
+func (b *tokenBucket) take(ctx context.Context, wait bool) (err error) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = math.Min(float64(b.conf.Burst), b.tokens+now.Sub(b.last).Seconds()*b.conf.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.mu.Unlock()
+		return
+	}
+	if wait == false {
+		b.mu.Unlock()
+		err = &RateLimitError{Micro: b.conf.Micro, CmdID: b.conf.CmdID}
+		return
+	}
+	d := time.Duration((1 - b.tokens) / b.conf.Rate * float64(time.Second))
+	if dl, ok := ctx.Deadline(); ok && now.Add(d).After(dl) {
+		b.mu.Unlock()
+		err = &RateLimitError{Micro: b.conf.Micro, CmdID: b.conf.CmdID}
+		return
+	}
+	b.tokens--
+	b.mu.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		// give the reservation back
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		err = ctx.Err()
+	}
+	return
+}
+
+// refund give back a token taken by a call not sent.
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens = math.Min(float64(b.conf.Burst), b.tokens+1)
+	b.mu.Unlock()
+}
+
+// cmdKey micro cmdid, cmdid 0: all cmdids of the micro
+type cmdKey struct {
+	micro string
+	cmdid uint64
+}
+
+// SetRateLimits replace all rate limits, buckets of unchanged limits keep their tokens.
+func (m *MicroClientConn) SetRateLimits(confs []svc.RateLimitConf) (err error) {
+	for _, c := range confs {
+		if c.Micro == "" || c.Rate <= 0 || c.Burst < 1 {
+			err = fmt.Errorf("SetRateLimits, error: Micro/Rate/Burst of %v", c)
+			return
+		}
+	}
+
+	m.rateMu.Lock()
+	defer m.rateMu.Unlock()
+	old, _ := m.rateLimits.Load().(map[cmdKey]*tokenBucket)
+	_t := make(map[cmdKey]*tokenBucket, len(confs))
+	for _, c := range confs {
+		k := cmdKey{c.Micro, c.CmdID}
+		if b, ok := old[k]; ok && b.conf == c {
+			_t[k] = b
+			continue
+		}
+		_t[k] = newTokenBucket(c)
+	}
+	m.rateLimits.Store(_t)
+	return
+}
+
+// rateLimit take tokens of the micro and the cmdid, none is taken if either rejects.
+func (m *MicroClientConn) rateLimit(ctx context.Context, ms string, cmdid uint64) (err error) {
+	limits, _ := m.rateLimits.Load().(map[cmdKey]*tokenBucket)
+	if len(limits) == 0 {
+		return
+	}
+	var taken *tokenBucket
+	for _, k := range []cmdKey{{ms, cmdid}, {ms, 0}} {
+		b, ok := limits[k]
+		if ok == false {
+			continue
+		}
+		wait := b.conf.Wait
+		if v, ok := ctx.Value(rateWaitKey{}).(bool); ok {
+			wait = v
+		}
+		if err = b.take(ctx, wait); err != nil {
+			if taken != nil {
+				taken.refund()
+			}
+			return
+		}
+		if k.cmdid == 0 {
+			break
+		}
+		taken = b
+	}
+	return
+}
+
+// WatchRateLimits load rate limits from key(svc.Config.RateLimitKey) and reload on change.
+func (m *MicroClientConn) WatchRateLimits(key string, endpoints []string) (err error) {
+	m.rateMu.Lock()
+	if m.rateWatcher != nil {
+		m.rateMu.Unlock()
+		err = fmt.Errorf("already watching: %s", m.rateWatcher.Path)
+		return
+	}
+	m.rateMu.Unlock()
+
+	w, e := svc.NewWatcher(key, endpoints, func(action, k, value string) {
+		if k != key {
+			return
+		}
+		if action == "DELETE" {
+			m.SetRateLimits(nil)
+			return
+		}
+		if e := m.loadRateLimits(value); e != nil {
+			logs.Logger.Warnf("loadRateLimits(%s), %s.", key, e)
+		}
+	})
+	if e != nil {
+		err = fmt.Errorf("svc.NewWatcher(%s), %s", key, e)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kvs, err := w.GetValues(ctx, key)
+	if err != nil {
+		w.Stop()
+		err = fmt.Errorf("w.GetValues(%s), %s", key, err)
+		return
+	}
+	if len(kvs) > 0 {
+		if err = m.loadRateLimits(kvs[0].Value); err != nil {
+			w.Stop()
+			return
+		}
+	}
+
+	m.rateMu.Lock()
+	m.rateWatcher = w
+	m.rateMu.Unlock()
+	go w.Start()
+	return
+}
+
+// StopRateLimitWatch stop WatchRateLimits, the loaded limits are kept.
+func (m *MicroClientConn) StopRateLimitWatch() {
+	m.rateMu.Lock()
+	defer m.rateMu.Unlock()
+	if m.rateWatcher == nil {
+		return
+	}
+	m.rateWatcher.Stop()
+	m.rateWatcher = nil
+}
+
+func (m *MicroClientConn) loadRateLimits(value string) (err error) {
+	var confs []svc.RateLimitConf
+	err = json.Unmarshal([]byte(value), &confs)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal(%s), %s", value, err)
+		return
+	}
+	err = m.SetRateLimits(confs)
+	return
+}
@@ -0,0 +1,36 @@
+package pprpcpool
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pprpc/core"
+	"xcthings.com/micro/svc"
+)
+
+func TestRateLimitRefund(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	err := m.SetRateLimits([]svc.RateLimitConf{
+		{Micro: "user", CmdID: 1, Rate: 0.001, Burst: 2},
+		{Micro: "user", Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := m.rateLimit(ctx, "user", 1); err != nil {
+		t.Fatalf("first call, %s", err)
+	}
+	// the micro bucket is empty, the cmdid token is given back
+	if err := m.rateLimit(ctx, "user", 1); IsRateLimited(err) == false {
+		t.Fatalf("second call = %v, want rate limited", err)
+	}
+	limits, _ := m.rateLimits.Load().(map[cmdKey]*tokenBucket)
+	b := limits[cmdKey{"user", 1}]
+	b.mu.Lock()
+	tokens := b.tokens
+	b.mu.Unlock()
+	if tokens < 1 {
+		t.Fatalf("cmdid tokens = %.3f after the micro rejected, want 1", tokens)
+	}
+}
@@ -0,0 +1,207 @@
+package pprpcpool
+
+// 断线重连
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/pprpc/core"
+	"github.com/pprpc/util/logs"
+)
+
+// host connection state
+const (
+	ConnConnecting   int32 = 1 // first dial
+	ConnReady        int32 = 2
+	ConnReconnecting int32 = 3 // dropped, redialing with backoff
+	ConnShutdown     int32 = 4 // removed from pool
+)
+
+// ReconnectConf reconnect backoff config
+type ReconnectConf struct {
+	CheckInterval time.Duration // connection state check interval
+	MinDelay      time.Duration
+	MaxDelay      time.Duration
+	Multiplier    float64
+	Jitter        float64 // 0-1, delay * (1 +- Jitter)
+}
+
+// DefaultReconnectConf .
+var DefaultReconnectConf = ReconnectConf{
+	CheckInterval: time.Second,
+	MinDelay:      500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+	Multiplier:    1.6,
+	Jitter:        0.2,
+}
+
+// SetReconnect set reconnect backoff, affect hosts added after it.
+func (r *RPCCliPool) SetReconnect(conf ReconnectConf) (err error) {
+	if conf.CheckInterval <= 0 || conf.MinDelay <= 0 || conf.MaxDelay < conf.MinDelay {
+		err = fmt.Errorf("SetReconnect, error: CheckInterval/MinDelay/MaxDelay is error")
+		return
+	}
+	if conf.Multiplier < 1 {
+		conf.Multiplier = 1
+	}
+	if conf.Jitter < 0 || conf.Jitter > 1 {
+		err = fmt.Errorf("SetReconnect, error: Jitter out of range: 0-1")
+		return
+	}
+	r.mu.Lock()
+	r.reconnect = conf
+	r.mu.Unlock()
+	return
+}
+
+// GetHostState return host connection state, ConnShutdown if addr not in pool
+func (r *RPCCliPool) GetHostState(addr string) (state int32, err error) {
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		state = ConnShutdown
+		err = fmt.Errorf("Load(%s), %s", addr, e)
+		return
+	}
+	state = v.(*ClientConnInfo).State()
+	return
+}
+
+// State connection state, Ready if any connection of the host is ready
+func (c *ClientConnInfo) State() (state int32) {
+	if atomic.LoadInt32(&c.state) == ConnShutdown {
+		return ConnShutdown
+	}
+	state = ConnConnecting
+	for _, sub := range c.getSubs() {
+		switch atomic.LoadInt32(&sub.state) {
+		case ConnReady:
+			return ConnReady
+		case ConnReconnecting:
+			state = ConnReconnecting
+		}
+	}
+	return
+}
+
+// replaceConn swap in the new connection unless the host is shutdown.
+func (c *ClientConnInfo) replaceConn(sub *subConn, conn *pprpc.TCPCliConn) (old *pprpc.TCPCliConn, ok bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if atomic.LoadInt32(&c.state) == ConnShutdown {
+		return
+	}
+	old = sub.cli
+	sub.cli = conn
+	ok = true
+	return
+}
+
+// shutdown stop supervisors and close the connections.
+func (c *ClientConnInfo) shutdown() {
+	c.doneOnce.Do(func() {
+		c.subsMu.Lock()
+		atomic.StoreInt32(&c.state, ConnShutdown)
+		c.subsMu.Unlock()
+		close(c.done)
+		for _, sub := range c.getSubs() {
+			if atomic.SwapInt32(&sub.state, ConnShutdown) == ConnReady {
+				atomic.AddInt32(&c.ready, -1)
+			}
+			if cli := sub.swapConn(nil); cli != nil {
+				cli.Close()
+			}
+		}
+	})
+}
+
+// casState change the connection state and record the transition.
+func (r *RPCCliPool) casState(info *ClientConnInfo, sub *subConn, old, state int32) bool {
+	if atomic.CompareAndSwapInt32(&sub.state, old, state) == false {
+		return false
+	}
+	if old == ConnReady {
+		atomic.AddInt32(&info.ready, -1)
+	}
+	if state == ConnReady {
+		atomic.AddInt32(&info.ready, 1)
+	}
+	if mt := r.getMetrics(); mt != nil {
+		mt.transition(r.micro, info.urladdr, state)
+	}
+	return true
+}
+
+// supervise watch a host connection, redial with backoff when dropped.
+func (r *RPCCliPool) supervise(info *ClientConnInfo, sub *subConn) {
+	r.mu.Lock()
+	conf := r.reconnect
+	r.mu.Unlock()
+
+	t := time.NewTicker(conf.CheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-info.done:
+			return
+		case <-t.C:
+		}
+		if sub.connected() {
+			r.casState(info, sub, ConnConnecting, ConnReady)
+			r.casState(info, sub, ConnReconnecting, ConnReady)
+			continue
+		}
+		r.casState(info, sub, ConnReady, ConnReconnecting)
+		if r.redial(info, sub, conf) == false {
+			return
+		}
+	}
+}
+
+// redial until connected, return false if the host or pool is closed.
+func (r *RPCCliPool) redial(info *ClientConnInfo, sub *subConn, conf ReconnectConf) bool {
+	for attempt := 0; ; attempt++ {
+		conn, err := r.dial(info.url)
+		if err == nil && conn != nil {
+			old, ok := info.replaceConn(sub, conn)
+			if ok == false {
+				conn.Close()
+				return false
+			}
+			if old != nil {
+				old.Close()
+			}
+			if sub.connected() {
+				r.casState(info, sub, ConnConnecting, ConnReady)
+				r.casState(info, sub, ConnReconnecting, ConnReady)
+				logs.Logger.Infof("reconnect %s ok, attempt: %d.", info.urladdr, attempt+1)
+				return true
+			}
+		} else {
+			logs.Logger.Debugf("reconnect %s, attempt: %d, %s.", info.urladdr, attempt+1, err)
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return false
+		case <-info.done:
+			return false
+		case <-time.After(backoff(conf, attempt)):
+		}
+	}
+}
+
+func backoff(conf ReconnectConf, attempt int) time.Duration {
+	d := float64(conf.MinDelay) * math.Pow(conf.Multiplier, float64(attempt))
+	if d > float64(conf.MaxDelay) {
+		d = float64(conf.MaxDelay)
+	}
+	d = d * (1 + conf.Jitter*(rand.Float64()*2-1))
+	return time.Duration(d)
+}
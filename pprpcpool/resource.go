@@ -0,0 +1,164 @@
+package pprpcpool
+
+// 按资源类型(ResSrv)路由
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/pprpc/core/packets"
+	"xcthings.com/micro/svc"
+)
+
+// SetHostResSrv set resource types served by the host, svc.RES_*.
+func (r *RPCCliPool) SetHostResSrv(addr string, res []int) (err error) {
+	v, e := r.clis.Get(addr)
+	if e != nil {
+		err = fmt.Errorf("SetHostResSrv(%s), %s", addr, e)
+		return
+	}
+	_t := make([]int, len(res))
+	copy(_t, res)
+	v.(*ClientConnInfo).resSrv.Store(_t)
+	return
+}
+
+// ResSrv resource types served by the host
+func (c *ClientConnInfo) ResSrv() []int {
+	res, _ := c.resSrv.Load().([]int)
+	return res
+}
+
+func (c *ClientConnInfo) hasRes(resID int) bool {
+	for _, v := range c.ResSrv() {
+		if v == resID {
+			return true
+		}
+	}
+	return false
+}
+
+// InvokeByRes call a host serving the resource type.
+func (r *RPCCliPool) InvokeByRes(ctx context.Context, resID int, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	var info *ClientConnInfo
+	info, err = r.lbsRes(resID)
+	if info == nil || err != nil {
+		return
+	}
+	pkg, resp, err = r.invoke(ctx, info, cmdid, req)
+	return
+}
+
+// InvokeAsyncByRes call a host serving the resource type, async.
+func (r *RPCCliPool) InvokeAsyncByRes(ctx context.Context, resID int, cmdid uint64, req interface{}) (err error) {
+	var info *ClientConnInfo
+	info, err = r.lbsRes(resID)
+	if info == nil || err != nil {
+		return
+	}
+	err = r.invokeAsync(ctx, info, cmdid, req)
+	return
+}
+
+// lbsRes pick from available hosts serving resID.
+func (r *RPCCliPool) lbsRes(resID int) (info *ClientConnInfo, err error) {
+	var all, hosts []*ClientConnInfo
+	for _, h := range r.allHosts() {
+		if h.hasRes(resID) == false {
+			continue
+		}
+		all = append(all, h)
+		if h.available() {
+			hosts = append(hosts, h)
+		}
+	}
+	hosts = r.preferLocal(all, hosts)
+	if len(hosts) == 0 {
+		err = fmt.Errorf("No microservices found, res: %d", resID)
+		return
+	}
+	info, err = r.getBalancer().Pick(hosts)
+	return
+}
+
+// resIndex register key of instances by resource type
+type resIndex map[int]map[string]svc.ValueRegService
+
+// add must hold m.resMu
+func (idx resIndex) add(key string, vrs svc.ValueRegService) {
+	for _, res := range vrs.ResSrv {
+		if idx[res] == nil {
+			idx[res] = make(map[string]svc.ValueRegService)
+		}
+		idx[res][key] = vrs
+	}
+}
+
+// del must hold m.resMu
+func (idx resIndex) del(key string, vrs svc.ValueRegService) {
+	for _, res := range vrs.ResSrv {
+		delete(idx[res], key)
+		if len(idx[res]) == 0 {
+			delete(idx, res)
+		}
+	}
+}
+
+func (m *MicroClientConn) indexRes(key string, old *svc.ValueRegService, vrs *svc.ValueRegService) {
+	m.resMu.Lock()
+	defer m.resMu.Unlock()
+	if m.resIdx == nil {
+		m.resIdx = make(resIndex)
+	}
+	if old != nil {
+		m.resIdx.del(key, *old)
+	}
+	if vrs != nil {
+		m.resIdx.add(key, *vrs)
+	}
+}
+
+// ListByRes return registered instances serving the resource type, sorted by key.
+func (m *MicroClientConn) ListByRes(resID int) (vrss []svc.ValueRegService) {
+	m.resMu.RLock()
+	defer m.resMu.RUnlock()
+	keys := make([]string, 0, len(m.resIdx[resID]))
+	for k := range m.resIdx[resID] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		vrss = append(vrss, m.resIdx[resID][k])
+	}
+	return
+}
+
+// GetByRes return any registered instance serving the resource type.
+func (m *MicroClientConn) GetByRes(resID int) (vrs svc.ValueRegService, err error) {
+	vrss := m.ListByRes(resID)
+	if len(vrss) == 0 {
+		err = fmt.Errorf("No microservices found, res: %d", resID)
+		return
+	}
+	vrs = vrss[rand.Intn(len(vrss))]
+	return
+}
+
+// InvokeByRes call an instance of the micro service serving the resource type.
+func (m *MicroClientConn) InvokeByRes(ctx context.Context, ms string, resID int, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	v, err := m.getMicro(ms)
+	if err != nil {
+		return
+	}
+	if err = m.rateLimit(ctx, ms, cmdid); err != nil {
+		return
+	}
+	if err = v.allow(); err != nil {
+		return
+	}
+	pkg, resp, err = v.RPCCliPool.InvokeByRes(ctx, resID, cmdid, req)
+	v.done(err)
+	return
+}
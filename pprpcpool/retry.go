@@ -0,0 +1,179 @@
+package pprpcpool
+
+// 重试策略
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pprpc/core/packets"
+)
+
+// RetryPolicy retry policy of a cmdid, only set it for idempotent cmdids.
+type RetryPolicy struct {
+	MaxAttempts int           // include the first call, 2-10
+	Backoff     time.Duration // first retry delay, doubled per retry
+	MaxBackoff  time.Duration
+	// Retryable default: all errors, except the context is done
+	Retryable func(err error) bool
+}
+
+// RetryBudget limit retries of the pool, a retry is allowed when
+// retries < MinRetriesPerSec*Window + Ratio*requests in the last Window.
+type RetryBudget struct {
+	Ratio            float64 // eg: 0.1, retries up to 10% of requests
+	MinRetriesPerSec int
+	Window           time.Duration // default 10s
+}
+
+// retryBudget two windows sliding counter
+type retryBudget struct {
+	conf RetryBudget
+
+	mu        sync.Mutex
+	start     time.Time
+	requests  int
+	retries   int
+	prevReqs  int
+	prevRetry int
+}
+
+func newRetryBudget(conf RetryBudget) *retryBudget {
+	if conf.Window <= 0 {
+		conf.Window = 10 * time.Second
+	}
+	return &retryBudget{conf: conf, start: time.Now()}
+}
+
+// roll must hold b.mu, return weight of the previous window.
+func (b *retryBudget) roll(now time.Time) float64 {
+	if d := now.Sub(b.start); d >= b.conf.Window {
+		if d >= 2*b.conf.Window {
+			b.prevReqs, b.prevRetry = 0, 0
+		} else {
+			b.prevReqs, b.prevRetry = b.requests, b.retries
+		}
+		b.requests, b.retries = 0, 0
+		b.start = now
+	}
+	return 1 - float64(now.Sub(b.start))/float64(b.conf.Window)
+}
+
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	b.roll(time.Now())
+	b.requests++
+	b.mu.Unlock()
+}
+
+// withdraw return false if the budget is exhausted.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := b.roll(time.Now())
+	requests := float64(b.requests) + w*float64(b.prevReqs)
+	retries := float64(b.retries) + w*float64(b.prevRetry)
+	limit := float64(b.conf.MinRetriesPerSec)*b.conf.Window.Seconds() + b.conf.Ratio*requests
+	if retries >= limit {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// SetRetryPolicy set retry policy of cmdid, Invoke retry it on other hosts.
+func (r *RPCCliPool) SetRetryPolicy(cmdid uint64, p RetryPolicy) (err error) {
+	if p.MaxAttempts < 2 || p.MaxAttempts > 10 {
+		err = fmt.Errorf("SetRetryPolicy, error: MaxAttempts out of range: 2-10")
+		return
+	}
+	if p.MaxBackoff < p.Backoff {
+		p.MaxBackoff = p.Backoff
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.retryPolicies.Load().(map[uint64]RetryPolicy)
+	m := make(map[uint64]RetryPolicy, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[cmdid] = p
+	r.retryPolicies.Store(m)
+	return
+}
+
+// DelRetryPolicy .
+func (r *RPCCliPool) DelRetryPolicy(cmdid uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, _ := r.retryPolicies.Load().(map[uint64]RetryPolicy)
+	m := make(map[uint64]RetryPolicy, len(old))
+	for k, v := range old {
+		if k != cmdid {
+			m[k] = v
+		}
+	}
+	r.retryPolicies.Store(m)
+}
+
+// SetRetryBudget limit retries of the pool
+func (r *RPCCliPool) SetRetryBudget(conf RetryBudget) (err error) {
+	if conf.Ratio < 0 || conf.MinRetriesPerSec < 0 {
+		err = fmt.Errorf("SetRetryBudget, error: Ratio/MinRetriesPerSec must be >= 0")
+		return
+	}
+	r.retryBudget.Store(newRetryBudget(conf))
+	return
+}
+
+func (r *RPCCliPool) getRetryPolicy(cmdid uint64) (p RetryPolicy, ok bool) {
+	m, _ := r.retryPolicies.Load().(map[uint64]RetryPolicy)
+	p, ok = m[cmdid]
+	return
+}
+
+func (r *RPCCliPool) getRetryBudget() *retryBudget {
+	b, _ := r.retryBudget.Load().(*retryBudget)
+	return b
+}
+
+func (r *RPCCliPool) invokeRetry(ctx context.Context, p RetryPolicy, cmdid uint64, req interface{}) (pkg *packets.CmdPacket, resp interface{}, err error) {
+	budget := r.getRetryBudget()
+	tried := make(map[*ClientConnInfo]bool)
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		var info *ClientConnInfo
+		info, err = r.lbs(tried)
+		if info == nil || err != nil {
+			return
+		}
+		pkg, resp, err = r.invoke(ctx, info, cmdid, req)
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return
+		}
+		if p.Retryable != nil && p.Retryable(err) == false {
+			return
+		}
+		if budget != nil && budget.withdraw() == false {
+			return
+		}
+		tried[info] = true
+
+		if delay > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > p.MaxBackoff {
+				delay = p.MaxBackoff
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package pprpcpool
+
+// 节点快照, 选择节点无锁
+
+// hostSnapshot immutable view of the pool hosts, replaced on membership change.
+type hostSnapshot struct {
+	hosts      []*ClientConnInfo
+	byAddr     map[string]*ClientConnInfo
+	byServerID map[string]*ClientConnInfo
+	ring       *hashRing
+}
+
+var emptySnapshot = &hostSnapshot{
+	byAddr:     map[string]*ClientConnInfo{},
+	byServerID: map[string]*ClientConnInfo{},
+}
+
+// lbHolder atomic.Value need a consistent type
+type lbHolder struct {
+	lb Balancer
+}
+
+func (r *RPCCliPool) getSnapshot() *hostSnapshot {
+	s, _ := r.snap.Load().(*hostSnapshot)
+	if s == nil {
+		return emptySnapshot
+	}
+	return s
+}
+
+func (r *RPCCliPool) getBalancer() Balancer {
+	return r.lb.Load().(*lbHolder).lb
+}
+
+// rebuild the snapshot from r.addrs, must hold r.mu.
+func (r *RPCCliPool) rebuild() {
+	s := new(hostSnapshot)
+	s.byAddr = make(map[string]*ClientConnInfo, len(r.addrs))
+	s.byServerID = make(map[string]*ClientConnInfo, len(r.addrs))
+	for _, addr := range r.addrs {
+		c, e := r.clis.Get(addr)
+		if e != nil {
+			continue
+		}
+		info := c.(*ClientConnInfo)
+		s.hosts = append(s.hosts, info)
+		s.byAddr[addr] = info
+		s.byServerID[info.url.Hostname()] = info
+	}
+	s.ring = newHashRing(r.hashReplicas, r.addrs)
+	r.snap.Store(s)
+	if mt := r.getMetrics(); mt != nil {
+		mt.setHosts(r.micro, len(s.hosts))
+	}
+}
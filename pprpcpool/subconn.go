@@ -0,0 +1,162 @@
+package pprpcpool
+
+// 单节点多连接
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pprpc/core"
+	"github.com/pprpc/core/pptcp"
+	"github.com/pprpc/util/logs"
+)
+
+// HostConns connections per host
+type HostConns struct {
+	Min int // dialed when the host is added, default 1
+	Max int // grow up to it, default Min
+	// GrowInflight grow a connection when the least loaded one has at least it in flight, 0: static
+	GrowInflight int32
+}
+
+// subConn one connection of a host
+type subConn struct {
+	mu       sync.RWMutex
+	cli      *pprpc.TCPCliConn
+	state    int32
+	inflight int32
+}
+
+func (s *subConn) getConn() *pprpc.TCPCliConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cli
+}
+
+func (s *subConn) swapConn(conn *pprpc.TCPCliConn) (old *pprpc.TCPCliConn) {
+	s.mu.Lock()
+	old = s.cli
+	s.cli = conn
+	s.mu.Unlock()
+	return
+}
+
+// connState replaced by tests
+var connState = func(c *pprpc.TCPCliConn) bool {
+	st, e := c.GetState()
+	return e == nil && st == pptcp.StateConnected
+}
+
+func (s *subConn) connected() bool {
+	cli := s.getConn()
+	if cli == nil {
+		return false
+	}
+	return connState(cli)
+}
+
+// SetHostConns set connections per host, affect hosts added after it.
+func (r *RPCCliPool) SetHostConns(conf HostConns) (err error) {
+	if conf.Min < 1 {
+		conf.Min = 1
+	}
+	if conf.Max < conf.Min {
+		conf.Max = conf.Min
+	}
+	if conf.Max > 64 || conf.GrowInflight < 0 {
+		err = fmt.Errorf("SetHostConns, error: Max out of range: 1-64, GrowInflight must be >= 0")
+		return
+	}
+	r.mu.Lock()
+	r.hostConns = conf
+	r.mu.Unlock()
+	return
+}
+
+// getSubs return a copy of the host connections.
+func (c *ClientConnInfo) getSubs() []*subConn {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+	subs := make([]*subConn, len(c.subs))
+	copy(subs, c.subs)
+	return subs
+}
+
+// pickConn least loaded connected connection, nil if none.
+func (c *ClientConnInfo) pickConn() (sub *subConn) {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+	for _, s := range c.subs {
+		if s.connected() == false {
+			continue
+		}
+		if sub == nil || atomic.LoadInt32(&s.inflight) < atomic.LoadInt32(&sub.inflight) {
+			sub = s
+		}
+	}
+	return
+}
+
+func (c *ClientConnInfo) getConn() *pprpc.TCPCliConn {
+	sub := c.pickConn()
+	if sub == nil {
+		return nil
+	}
+	return sub.getConn()
+}
+
+// Conns number of connections of the host
+func (c *ClientConnInfo) Conns() int {
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+	return len(c.subs)
+}
+
+// addSub add a connection and supervise it, false if the host is shutdown.
+func (r *RPCCliPool) addSub(info *ClientConnInfo, conn *pprpc.TCPCliConn) bool {
+	sub := &subConn{cli: conn, state: ConnConnecting}
+	if sub.connected() {
+		sub.state = ConnReady
+	}
+	info.subsMu.Lock()
+	if atomic.LoadInt32(&info.state) == ConnShutdown {
+		info.subsMu.Unlock()
+		return false
+	}
+	info.subs = append(info.subs, sub)
+	if sub.state == ConnReady {
+		atomic.AddInt32(&info.ready, 1)
+	}
+	info.subsMu.Unlock()
+	if mt := r.getMetrics(); mt != nil {
+		mt.transition(r.micro, info.urladdr, sub.state)
+	}
+
+	go r.supervise(info, sub)
+	return true
+}
+
+// grow dial one more connection when the host is busy.
+func (r *RPCCliPool) grow(info *ClientConnInfo, sub *subConn) {
+	conf := info.conns
+	if conf.GrowInflight <= 0 || atomic.LoadInt32(&sub.inflight) < conf.GrowInflight {
+		return
+	}
+	if info.Conns() >= conf.Max || atomic.CompareAndSwapInt32(&info.growing, 0, 1) == false {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&info.growing, 0)
+		conn, err := r.dial(info.url)
+		if err != nil || conn == nil {
+			logs.Logger.Debugf("grow %s, pprpc.Dail(), %s.", info.urladdr, err)
+			return
+		}
+		if r.addSub(info, conn) == false {
+			conn.Close()
+			return
+		}
+		logs.Logger.Debugf("grow %s, conns: %d.", info.urladdr, info.Conns())
+	}()
+}
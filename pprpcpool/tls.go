@@ -0,0 +1,80 @@
+package pprpcpool
+
+// TLS 客户端配置
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConf client tls config, used by tls:// hosts
+type TLSConf struct {
+	CAFile     string // CA bundle, PEM; empty: system roots
+	CertFile   string // client cert for mTLS, PEM
+	KeyFile    string
+	ServerName string // default: host of the url
+	MinVersion uint16 // default tls.VersionTLS12
+}
+
+// NewTLSConfig create *tls.Config from TLSConf
+func NewTLSConfig(conf TLSConf) (tc *tls.Config, err error) {
+	tc = new(tls.Config)
+	tc.ServerName = conf.ServerName
+	tc.MinVersion = conf.MinVersion
+	if tc.MinVersion == 0 {
+		tc.MinVersion = tls.VersionTLS12
+	}
+
+	if conf.CAFile != "" {
+		pem, e := ioutil.ReadFile(conf.CAFile)
+		if e != nil {
+			err = fmt.Errorf("ioutil.ReadFile(%s), %s", conf.CAFile, e)
+			return
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(pem) == false {
+			err = fmt.Errorf("AppendCertsFromPEM(%s), no certificate found", conf.CAFile)
+			return
+		}
+		tc.RootCAs = pool
+	}
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, e := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if e != nil {
+			err = fmt.Errorf("tls.LoadX509KeyPair(%s, %s), %s", conf.CertFile, conf.KeyFile, e)
+			return
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return
+}
+
+// SetTLS set client tls config, affect connections dialed after it.
+func (r *RPCCliPool) SetTLS(conf TLSConf) (err error) {
+	var tc *tls.Config
+	tc, err = NewTLSConfig(conf)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.tlsConfig = tc
+	r.mu.Unlock()
+	return
+}
+
+func (r *RPCCliPool) getTLSConfig(host string) (tc *tls.Config, err error) {
+	r.mu.Lock()
+	tc = r.tlsConfig
+	r.mu.Unlock()
+	if tc == nil {
+		err = fmt.Errorf("not set TLS config")
+		return
+	}
+	if tc.ServerName == "" {
+		tc = tc.Clone()
+		tc.ServerName = host
+	}
+	return
+}
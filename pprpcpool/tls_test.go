@@ -0,0 +1,214 @@
+package pprpcpool
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pprpc/core"
+	"xcthings.com/micro/svc"
+)
+
+// testCert a certificate and its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert sign a certificate by parent, nil parent: self signed CA.
+func newTestCert(t *testing.T, cn string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey(), %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.DNSNames = []string{cn}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate(%s), %s", cn, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate(%s), %s", cn, err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write the certificate and key PEM files into dir, return their paths.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey(), %s", err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", c.der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatalf("os.WriteFile(%s), %s", path, err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	cli := newTestCert(t, "client", ca, x509.ExtKeyUsageClientAuth)
+	certFile, keyFile := cli.write(t, dir, "client")
+	junk := filepath.Join(dir, "junk.pem")
+	if err := os.WriteFile(junk, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := NewTLSConfig(TLSConf{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("NewTLSConfig(), %s", err)
+	}
+	if tc.RootCAs == nil || len(tc.Certificates) != 1 {
+		t.Fatalf("NewTLSConfig() RootCAs: %v, Certificates: %d", tc.RootCAs, len(tc.Certificates))
+	}
+	if tc.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("MinVersion = %x, want TLS 1.2", tc.MinVersion)
+	}
+
+	bad := map[string]TLSConf{
+		"missing CA":  {CAFile: filepath.Join(dir, "none.pem")},
+		"CA not PEM":  {CAFile: junk},
+		"key missing": {CertFile: certFile},
+		"key of CA":   {CertFile: certFile, KeyFile: filepath.Join(dir, "ca.key")},
+	}
+	for name, conf := range bad {
+		if _, err := NewTLSConfig(conf); err == nil {
+			t.Errorf("%s: NewTLSConfig(%+v) = nil error", name, conf)
+		}
+	}
+}
+
+func TestTLSConfigMutualAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	srv := newTestCert(t, "localhost", ca, x509.ExtKeyUsageServerAuth)
+	srvCert, srvKey := srv.write(t, dir, "server")
+	cli := newTestCert(t, "client", ca, x509.ExtKeyUsageClientAuth)
+	cliCert, cliKey := cli.write(t, dir, "client")
+
+	kp, err := tls.LoadX509KeyPair(srvCert, srvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{kp},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("tls.Listen(), %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.(*tls.Conn).Handshake()
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+
+	r := NewRPCCliPool()
+	dial := func(conf TLSConf) error {
+		if err := r.SetTLS(conf); err != nil {
+			return err
+		}
+		tc, err := r.getTLSConfig("localhost")
+		if err != nil {
+			return err
+		}
+		c, err := tls.Dial("tcp", ln.Addr().String(), tc)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		// the server rejects a missing client cert after the client handshake
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	if err := dial(TLSConf{CAFile: caFile, CertFile: cliCert, KeyFile: cliKey}); err != nil {
+		t.Fatalf("mTLS handshake, %s", err)
+	}
+	if err := dial(TLSConf{CAFile: caFile}); err == nil {
+		t.Fatalf("handshake without client cert succeeded")
+	}
+	if err := dial(TLSConf{CertFile: cliCert, KeyFile: cliKey}); err == nil {
+		t.Fatalf("handshake trusted a server signed by an unknown CA")
+	}
+}
+
+func TestMicroSetTLSAfterHosts(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	// the host is kept and redialed, no connection to close
+	setDialFail(t)
+
+	vrs := svc.ValueRegService{Name: "user", LanIP: "10.0.2.1", Listen: []svc.LisConf{{URI: "tcp://0.0.0.0:6061"}}}
+	if err := m.AddHost("/reg/user/1", vrs); err != nil {
+		t.Fatalf("AddHost(), %s", err)
+	}
+	if err := m.SetTLS(TLSConf{}); err == nil {
+		t.Fatalf("SetTLS() after AddHost = nil error")
+	}
+	if err := m.DelHost("/reg/user/1"); err != nil {
+		t.Fatalf("DelHost(), %s", err)
+	}
+	if n := len(v.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after DelHost, want 0", n)
+	}
+}
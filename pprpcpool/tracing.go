@@ -0,0 +1,116 @@
+package pprpcpool
+
+// OpenTelemetry 链路追踪
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pprpc/core/packets"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "xcthings.com/micro/pprpcpool"
+
+// MetadataCarrier request messages carrying string metadata to the server.
+// TCPCliConn.Invoke does not expose the packet header, the trace context
+// rides in the request message, so only requests implementing it are traced
+// across the call; generated messages need GetMetadata/SetMetadata added
+// over a metadata field, the server extracts it by ExtractTrace.
+type MetadataCarrier interface {
+	GetMetadata() map[string]string
+	SetMetadata(md map[string]string)
+}
+
+// RequestCloner requests copied per attempt to carry the trace context,
+// Clone must return a MetadataCarrier whose metadata is not shared with the original.
+type RequestCloner interface {
+	Clone() interface{}
+}
+
+// TracingInterceptor create a client span per call; nil tp/prop use the otel globals.
+// the caller's request is never modified, it may be shared by concurrent calls:
+// the trace context is injected into a clone of requests implementing RequestCloner
+// and MetadataCarrier, other requests are sent as is, the span has pprpc.trace_propagated=false.
+func TracingInterceptor(tp trace.TracerProvider, prop propagation.TextMapPropagator) Interceptor {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if prop == nil {
+		prop = otel.GetTextMapPropagator()
+	}
+	tracer := tp.Tracer(tracerName)
+
+	return func(ctx context.Context, info *InvokeInfo, req interface{}, invoker Invoker) (pkg *packets.CmdPacket, resp interface{}, err error) {
+		ctx, span := tracer.Start(ctx, spanName(info.Micro, info.CmdID),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "pprpc"),
+				attribute.String("pprpc.micro", info.Micro),
+				attribute.Int64("pprpc.cmdid", int64(info.CmdID)),
+				attribute.String("pprpc.host", info.Host),
+				attribute.String("pprpc.server_id", info.ServerID),
+				attribute.Bool("pprpc.async", info.Async),
+			))
+		defer span.End()
+
+		// inject into a clone, the caller's request may be shared
+		var c MetadataCarrier
+		if rc, ok := req.(RequestCloner); ok {
+			c, _ = rc.Clone().(MetadataCarrier)
+		}
+		if c != nil {
+			md := make(map[string]string)
+			for k, v := range c.GetMetadata() {
+				md[k] = v
+			}
+			prop.Inject(ctx, propagation.MapCarrier(md))
+			c.SetMetadata(md)
+			req = c
+		} else {
+			span.SetAttributes(attribute.Bool("pprpc.trace_propagated", false))
+		}
+
+		pkg, resp, err = invoker(ctx, info, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return
+	}
+}
+
+// ExtractTrace server side, return ctx with the remote span context carried by req.
+func ExtractTrace(ctx context.Context, req interface{}, prop propagation.TextMapPropagator) context.Context {
+	c, ok := req.(MetadataCarrier)
+	if ok == false {
+		return ctx
+	}
+	if prop == nil {
+		prop = otel.GetTextMapPropagator()
+	}
+	return prop.Extract(ctx, propagation.MapCarrier(c.GetMetadata()))
+}
+
+// StartServerSpan server side, continue the trace carried by req, the caller must End the span.
+func StartServerSpan(ctx context.Context, tp trace.TracerProvider, prop propagation.TextMapPropagator, micro string, cmdid uint64, req interface{}) (context.Context, trace.Span) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	ctx = ExtractTrace(ctx, req, prop)
+	return tp.Tracer(tracerName).Start(ctx, spanName(micro, cmdid),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "pprpc"),
+			attribute.String("pprpc.micro", micro),
+			attribute.Int64("pprpc.cmdid", int64(cmdid)),
+		))
+}
+
+func spanName(micro string, cmdid uint64) string {
+	return fmt.Sprintf("pprpc/%s/%d", micro, cmdid)
+}
@@ -0,0 +1,142 @@
+package pprpcpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/pprpc/core/packets"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceReq request carrying metadata
+type traceReq struct {
+	md map[string]string
+}
+
+func (r *traceReq) GetMetadata() map[string]string   { return r.md }
+func (r *traceReq) SetMetadata(md map[string]string) { r.md = md }
+
+// cloneReq traceReq copied per attempt
+type cloneReq struct {
+	traceReq
+}
+
+func (r *cloneReq) Clone() interface{} {
+	c := new(cloneReq)
+	for k, v := range r.md {
+		if c.md == nil {
+			c.md = make(map[string]string)
+		}
+		c.md[k] = v
+	}
+	return c
+}
+
+func newTestTracer() (*sdktrace.TracerProvider, *tracetest.SpanRecorder, propagation.TextMapPropagator) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	return tp, sr, propagation.TraceContext{}
+}
+
+// traceCall run req through the tracing interceptor, the invoker plays the server,
+// return the request the server received.
+func traceCall(t *testing.T, tp trace.TracerProvider, prop propagation.TextMapPropagator, info *InvokeInfo, req interface{}) (sent interface{}) {
+	ic := TracingInterceptor(tp, prop)
+	_, _, err := ic(context.Background(), info, req, func(ctx context.Context, info *InvokeInfo, req interface{}) (*packets.CmdPacket, interface{}, error) {
+		sent = req
+		// the server only has the request
+		_, span := StartServerSpan(context.Background(), tp, prop, info.Micro, info.CmdID, req)
+		span.End()
+		return nil, nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// checkParent the server span is the child of the client span.
+func checkParent(t *testing.T, sr *tracetest.SpanRecorder) {
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended spans = %d, want 2", len(spans))
+	}
+	server, client := spans[0], spans[1]
+	if server.SpanKind() != trace.SpanKindServer || client.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("span kinds: %s, %s", server.SpanKind(), client.SpanKind())
+	}
+	if server.Parent().SpanID() != client.SpanContext().SpanID() || server.Parent().IsRemote() == false {
+		t.Fatalf("server span parent %s, want remote client span %s", server.Parent().SpanID(), client.SpanContext().SpanID())
+	}
+	if server.SpanContext().TraceID() != client.SpanContext().TraceID() {
+		t.Fatalf("server trace %s, client trace %s", server.SpanContext().TraceID(), client.SpanContext().TraceID())
+	}
+}
+
+func TestTracingParent(t *testing.T) {
+	tp, sr, prop := newTestTracer()
+	req := &cloneReq{traceReq{md: map[string]string{"user": "1"}}}
+	sent := traceCall(t, tp, prop, &InvokeInfo{Micro: "user", CmdID: 1}, req).(*cloneReq)
+	checkParent(t, sr)
+	if sent.md["user"] != "1" || sent.md["traceparent"] == "" {
+		t.Fatalf("metadata %v, want user and traceparent", sent.md)
+	}
+}
+
+// the caller's request is never modified, it may be shared by concurrent calls
+func TestTracingSharedRequest(t *testing.T) {
+	infos := []*InvokeInfo{{Micro: "user", CmdID: 1}, {Micro: "user", CmdID: 1, Hedged: true}, {Micro: "user", CmdID: 1, Fanout: true}}
+	for _, info := range infos {
+		tp, sr, prop := newTestTracer()
+		req := &cloneReq{traceReq{md: map[string]string{"user": "1"}}}
+		sent := traceCall(t, tp, prop, info, req)
+		checkParent(t, sr)
+		if sent == req {
+			t.Fatalf("hedged %v fanout %v: the caller's request was sent", info.Hedged, info.Fanout)
+		}
+		if _, ok := req.md["traceparent"]; ok || len(req.md) != 1 {
+			t.Fatalf("the caller's request was modified: %v", req.md)
+		}
+	}
+
+	// not a RequestCloner, sent as is without the trace context
+	tp, sr, prop := newTestTracer()
+	req := &traceReq{}
+	if sent := traceCall(t, tp, prop, &InvokeInfo{}, req); sent != req || req.md != nil {
+		t.Fatalf("sent %v, metadata %v, want the request unchanged", sent, req.md)
+	}
+	spans := sr.Ended()
+	if len(spans) != 2 || spans[0].Parent().IsValid() {
+		t.Fatalf("the server span has a parent without the trace context")
+	}
+	propagated := true
+	for _, kv := range spans[1].Attributes() {
+		if kv.Key == "pprpc.trace_propagated" {
+			propagated = kv.Value.AsBool()
+		}
+	}
+	if propagated {
+		t.Fatalf("client span not marked pprpc.trace_propagated=false")
+	}
+}
+
+func TestTracingConcurrentShared(t *testing.T) {
+	tp, _, prop := newTestTracer()
+	ic := TracingInterceptor(tp, prop)
+	req := &cloneReq{traceReq{md: map[string]string{"user": "1"}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ic(context.Background(), &InvokeInfo{}, req, func(ctx context.Context, info *InvokeInfo, req interface{}) (*packets.CmdPacket, interface{}, error) {
+				return nil, nil, nil
+			})
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,190 @@
+package pprpcpool
+
+// 订阅注册中心, 自动增删节点
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pprpc/util/logs"
+	"xcthings.com/micro/svc"
+)
+
+// DefaultReconcileInterval full listing interval of Watch
+const DefaultReconcileInterval = 60 * time.Second
+
+// Watch subscribe /register/<region>/, add and del hosts of the micros added by AddMicro.
+// a full listing is reconciled every interval(<= 0: DefaultReconcileInterval) to repair missed events.
+func (m *MicroClientConn) Watch(region string, endpoints []string, interval time.Duration) (err error) {
+	if region == "" {
+		err = fmt.Errorf("not set region")
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	if m.watcher != nil {
+		err = fmt.Errorf("already watching: %s", m.watcher.Path)
+		return
+	}
+
+	path := fmt.Sprintf("/register/%s/", region)
+	w, e := svc.NewWatcher(path, endpoints, m.watchCB)
+	if e != nil {
+		err = fmt.Errorf("svc.NewWatcher(%s), %s", path, e)
+		return
+	}
+	// kept across StopWatch, the listing drops keys deleted while stopped
+	if m.watched == nil {
+		m.watched = make(map[string]bool)
+	}
+	err = m.reconcile(w)
+	if err != nil {
+		w.Stop()
+		return
+	}
+
+	m.watcher = w
+	var ctx context.Context
+	ctx, m.watchCancel = context.WithCancel(context.Background())
+	go w.Start()
+	go m.reconcileLoop(ctx, w, interval)
+	return
+}
+
+// StopWatch stop Watch, hosts already added are kept until Watch is called again.
+func (m *MicroClientConn) StopWatch() {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	if m.watcher == nil {
+		return
+	}
+	m.watchCancel()
+	m.watcher.Stop()
+	m.watcher = nil
+}
+
+func (m *MicroClientConn) watchCB(action, key, value string) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	switch action {
+	case "PUT":
+		m.watchPut(key, value)
+	case "DELETE":
+		m.watchDel(key)
+	}
+}
+
+// watchPut must hold m.watchMu
+func (m *MicroClientConn) watchPut(key, value string) {
+	var vrs svc.ValueRegService
+	err := json.Unmarshal([]byte(value), &vrs)
+	if err != nil {
+		logs.Logger.Warnf("json.Unmarshal(%s), %s.", key, err)
+		return
+	}
+	if vrs.Name == "" {
+		vrs.Name = nameOfKey(key)
+	}
+	if m.hasMicro(vrs.Name) == false {
+		return
+	}
+	err = m.AddHost(key, vrs)
+	if err != nil {
+		logs.Logger.Warnf("m.AddHost(%s), %s.", key, err)
+	}
+	// the host is in the pool, the DELETE must remove it
+	if _, e := m.regCache.Get(key); e == nil {
+		m.watched[key] = true
+	}
+}
+
+// watchDel must hold m.watchMu
+func (m *MicroClientConn) watchDel(key string) {
+	if m.watched[key] == false {
+		return
+	}
+	err := m.DelHost(key)
+	if err != nil {
+		logs.Logger.Warnf("m.DelHost(%s), %s.", key, err)
+		return
+	}
+	delete(m.watched, key)
+}
+
+func (m *MicroClientConn) reconcileLoop(ctx context.Context, w *svc.Watcher, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.watchMu.Lock()
+			if ctx.Err() == nil {
+				if err := m.reconcile(w); err != nil {
+					logs.Logger.Warnf("reconcile, %s.", err)
+				}
+			}
+			m.watchMu.Unlock()
+		}
+	}
+}
+
+// listRegs replaced by tests
+var listRegs = func(ctx context.Context, w *svc.Watcher) ([]svc.KeyValue, error) {
+	return w.GetValues(ctx, w.Path)
+}
+
+// watchMicro add hosts of a micro added while watching.
+func (m *MicroClientConn) watchMicro() {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	if m.watcher == nil {
+		return
+	}
+	if err := m.reconcile(m.watcher); err != nil {
+		logs.Logger.Warnf("reconcile, %s.", err)
+	}
+}
+
+// reconcile apply a full listing, must hold m.watchMu
+func (m *MicroClientConn) reconcile(w *svc.Watcher) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	kvs, err := listRegs(ctx, w)
+	if err != nil {
+		err = fmt.Errorf("w.GetValues(%s), %s", w.Path, err)
+		return
+	}
+
+	exist := make(map[string]bool, len(kvs))
+	for _, kv := range kvs {
+		exist[kv.Key] = true
+		m.watchPut(kv.Key, kv.Value)
+	}
+	for key := range m.watched {
+		if exist[key] == false {
+			m.watchDel(key)
+		}
+	}
+	return
+}
+
+// nameOfKey /register/region/msname/lanip
+func nameOfKey(key string) string {
+	s := strings.Split(strings.Trim(key, "/"), "/")
+	if len(s) != 4 {
+		return ""
+	}
+	return s[2]
+}
+
+func (m *MicroClientConn) hasMicro(ms string) bool {
+	return m.Micro(ms) != nil
+}
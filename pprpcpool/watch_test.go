@@ -0,0 +1,111 @@
+package pprpcpool
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pprpc/core"
+	"xcthings.com/micro/svc"
+)
+
+func TestWatchDialFailure(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	m.watched = make(map[string]bool)
+	setDialFail(t)
+
+	key := "/register/cn/user/10.0.3.1"
+	value, _ := json.Marshal(svc.ValueRegService{Name: "user", LanIP: "10.0.3.1", Listen: []svc.LisConf{{URI: "tcp://0.0.0.0:6061"}}})
+	m.watchCB("PUT", key, string(value))
+	if n := len(v.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d after PUT, want 1 redialing", n)
+	}
+	if m.watched[key] == false {
+		t.Fatalf("%s not watched after PUT", key)
+	}
+
+	m.watchCB("DELETE", key, "")
+	if n := len(v.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after DELETE, want 0", n)
+	}
+	if _, err := m.regCache.Get(key); err == nil {
+		t.Fatalf("%s still registered after DELETE", key)
+	}
+	if m.watched[key] {
+		t.Fatalf("%s still watched after DELETE", key)
+	}
+}
+
+// setListRegs list kvs as the registry until the test ends.
+func setListRegs(t *testing.T, kvs *[]svc.KeyValue) {
+	old := listRegs
+	listRegs = func(ctx context.Context, w *svc.Watcher) ([]svc.KeyValue, error) {
+		return *kvs, nil
+	}
+	t.Cleanup(func() { listRegs = old })
+}
+
+func regValue(t *testing.T, name, lanip string) string {
+	value, err := json.Marshal(svc.ValueRegService{Name: name, LanIP: lanip, Listen: []svc.LisConf{{URI: "tcp://0.0.0.0:6061"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value)
+}
+
+func TestWatchAddMicro(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	setDialFail(t)
+	kvs := []svc.KeyValue{{Key: "/register/cn/user/10.0.4.1", Value: regValue(t, "user", "10.0.4.1")}}
+	setListRegs(t, &kvs)
+	// watching, the micro is not added yet
+	m.watcher = &svc.Watcher{Path: "/register/cn/"}
+	m.watched = make(map[string]bool)
+	if err := m.reconcile(m.watcher); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	if n := len(v.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d after AddMicro, want 1", n)
+	}
+}
+
+// the keys deleted while the watch is stopped are dropped by the listing of the next Watch
+func TestWatchRestartDropsDeleted(t *testing.T) {
+	m := NewMicroClientConn(new(pprpc.Service))
+	if err := m.AddMicro("user"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := m.getMicro("user")
+	t.Cleanup(v.ctxCancel)
+	setDialFail(t)
+	kvs := []svc.KeyValue{{Key: "/register/cn/user/10.0.5.1", Value: regValue(t, "user", "10.0.5.1")}}
+	setListRegs(t, &kvs)
+	w := &svc.Watcher{Path: "/register/cn/"}
+	m.watched = make(map[string]bool)
+	if err := m.reconcile(w); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(v.allHosts()); n != 1 {
+		t.Fatalf("hosts = %d, want 1", n)
+	}
+
+	// stopped, the key is deleted, watching again
+	kvs = nil
+	if err := m.reconcile(w); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(v.allHosts()); n != 0 {
+		t.Fatalf("hosts = %d after the key was deleted, want 0", n)
+	}
+}
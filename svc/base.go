@@ -78,16 +78,25 @@ type ValueLogConf struct {
 // key: /register/region/msname/lanip
 type ValueRegService struct {
 	Region string    `json:"region,omitempty"`
+	Zone   string    `json:"zone,omitempty"` // availability zone in the region
 	Name   string    `json:"name,omitempty"`
 	ResSrv []int     `json:"res_srv,omitempty"`
 	LanIP  string    `json:"lan_ip,omitempty"`
 	Listen []LisConf `json:"listen,omitempty"`
+	Load   *LoadInfo `json:"load,omitempty"`
+}
+
+// LoadInfo instance load, published with the register value
+type LoadInfo struct {
+	CPU []float32 `json:"cpu,omitempty"` //
+	Mem []float32 `json:"mem,omitempty"` // total, free, 比例
+	Sys []float32 `json:"sys,omitempty"` // 5, 10, 15
 }
 
 // LisConf listen conf
 // key: /conf/region/listen/lanip/msname
 type LisConf struct {
-	URI         string `json:"uri,omitempty"` // tcp://ip:port, udp://ip:port
+	URI         string `json:"uri,omitempty"` // tcp://ip:port, udp://ip:port, tls://ip:port
 	ReadTimeout int64  `json:"read_timeout,omitempty"`
 	TLSCrt      string `json:"tls_crt,omitempty"`
 	TLSKey      string `json:"tls_key,omitempty"`
@@ -119,6 +128,16 @@ type PpmqcliConf struct {
 	MsgCount int32 `json:"msg_count,omitempty"`
 }
 
+// RateLimitConf client rate limit of a micro service, token bucket
+// key: /conf/region/lanip/msname/ratelimit
+type RateLimitConf struct {
+	Micro string  `json:"micro,omitempty"`
+	CmdID uint64  `json:"cmdid,omitempty"` // 0: all cmdids of the micro
+	Rate  float64 `json:"rate,omitempty"`  // requests per second
+	Burst int     `json:"burst,omitempty"`
+	Wait  bool    `json:"wait,omitempty"` // wait for a token, false: fail fast
+}
+
 // MSConfig micro service config
 type MSConfig struct {
 	Public        PublicConf      `json:"public,omitempty"`         // key: /conf/region/public/lanip/msname
@@ -127,6 +146,7 @@ type MSConfig struct {
 	Dbs           []ValueDbconf   `json:"dbs,omitempty"`            // key: /conf/region/db/dbname
 	Ppmqclis      []PpmqcliConf   `json:"ppmqclis,omitempty"`       // key: /conf/region/ppmqcli/lanip/msname
 	PrivateConfig json.RawMessage `json:"private_config,omitempty"` // key: /conf/region/private/lanip/msname
+	RateLimits    []RateLimitConf `json:"rate_limits,omitempty"`    // key: /conf/region/lanip/msname/ratelimit
 }
 
 // MicroClient micrl service client
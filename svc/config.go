@@ -73,6 +73,10 @@ func (c *Config) GetAll() (err error) {
 	if err != nil {
 		logs.Logger.Warnf("c.PpmqcliConf(), %s.", err)
 	}
+	// optional, most services have no rate limit
+	if e := c.RateLimitConf(); e != nil {
+		logs.Logger.Debugf("c.RateLimitConf(), %s.", e)
+	}
 	if c.private {
 		err = c.PrivateConf()
 		if err != nil {
@@ -211,6 +215,23 @@ func (c *Config) PrivateConf() (err error) {
 	return
 }
 
+// RateLimitConf get client rate limit config
+// key: /conf/region/lanip/msname/ratelimit
+func (c *Config) RateLimitConf() (err error) {
+	var _t []RateLimitConf
+	err = c.getValueObj(c.RateLimitKey(), &_t)
+	if err != nil {
+		return
+	}
+	c.Conf.RateLimits = _t
+	return
+}
+
+// RateLimitKey etcd key of the client rate limit config, watch it to reload.
+func (c *Config) RateLimitKey() string {
+	return fmt.Sprintf("/conf/%s/%s/%s/ratelimit", c.region, c.lanip, c.name)
+}
+
 func (c *Config) getValueObj(key string, obj interface{}) (err error) {
 	var kvs []KeyValue
 	ctx, _ := context.WithTimeout(context.TODO(), 3*time.Second)
@@ -255,6 +276,29 @@ func GetTCPURL(reg ValueRegService) (url string, err error) {
 	return
 }
 
+// GetTLSURL get listen tls url
+func GetTLSURL(reg ValueRegService) (url string, err error) {
+	if reg.LanIP == "" || len(reg.Listen) == 0 {
+		err = fmt.Errorf("ValueRegService value: LanIP/Listen is error")
+		return
+	}
+	var port int32
+	for _, lis := range reg.Listen {
+		port, err = getTLSPort(lis)
+		if err != nil {
+			continue
+		}
+		break
+	}
+	if port == 0 {
+		err = fmt.Errorf("not find listen: [%v] tls uri", reg.Listen)
+		return
+	}
+
+	url = fmt.Sprintf("tls://%s:%d", reg.LanIP, port)
+	return
+}
+
 func getTCPPort(lis LisConf) (port int32, err error) {
 	u, e := url.ParseRequestURI(lis.URI)
 	if e != nil {
@@ -275,6 +319,26 @@ func getTCPPort(lis LisConf) (port int32, err error) {
 	return
 }
 
+func getTLSPort(lis LisConf) (port int32, err error) {
+	u, e := url.ParseRequestURI(lis.URI)
+	if e != nil {
+		logs.Logger.Warnf("url.ParseRequestURI(%s), %s.", lis, e)
+		return
+	}
+	if u.Scheme != "tls" {
+		err = fmt.Errorf("uri scheme : %s, not tls", u.Scheme)
+		return
+	}
+	_t, e := strconv.Atoi(u.Port())
+	if e == nil {
+		port = int32(_t)
+		return
+	}
+
+	err = e
+	return
+}
+
 func getUDPPort(lis LisConf) (port int32, err error) {
 	u, e := url.ParseRequestURI(lis.URI)
 	if e != nil {
@@ -330,6 +394,18 @@ func GetListenTCPPorts(liss []LisConf) (ports []int32) {
 	return
 }
 
+// GetListenTLSPorts get listen tls ports
+func GetListenTLSPorts(liss []LisConf) (ports []int32) {
+	for _, row := range liss {
+		_t, err := getTLSPort(row)
+		if err != nil {
+			continue
+		}
+		ports = append(ports, _t)
+	}
+	return
+}
+
 // GetListenUDPPorts get listen udp ports
 func GetListenUDPPorts(liss []LisConf) (ports []int32) {
 	for _, row := range liss {
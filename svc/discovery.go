@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	//"github.com/coreos/etcd/clientv3"
@@ -26,6 +27,7 @@ type Agent struct {
 	client    *clientv3.Client
 	key       string
 	value     ValueRegService
+	valueMu   sync.Mutex // value, leaseid
 	leaseTime int64
 }
 
@@ -81,7 +83,7 @@ start:
 				logs.Logger.Warnf("keep alive closed, key: %s, restart KeepAlive.", s.key)
 				err := s.revoke(ctx)
 				if err != nil {
-					logs.Logger.Warnf("s.client.Revoke(ctx, %x), %s.", s.leaseid, err)
+					logs.Logger.Warnf("s.revoke(ctx), %s.", err)
 				}
 				goto start
 			}
@@ -104,7 +106,9 @@ func (s *Agent) Stop() (err error) {
 }
 
 func (s *Agent) keepAlive(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	s.valueMu.Lock()
 	value, _ := json.Marshal(s.value)
+	s.valueMu.Unlock()
 	resp, err := s.client.Grant(ctx, s.leaseTime)
 	if err != nil {
 		return nil, fmt.Errorf("s.client.Grant(ctx, %d), %s", s.leaseTime, err)
@@ -117,17 +121,43 @@ func (s *Agent) keepAlive(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveR
 		return nil, fmt.Errorf("s.client.Put(ctx, key, value, %x), %s", resp.ID, err)
 	}
 
+	s.valueMu.Lock()
 	s.leaseid = resp.ID
-	logs.Logger.Debugf("etcd keepAlive ok, key: %s, leaseid: [%x].", s.key, s.leaseid)
+	s.valueMu.Unlock()
+	logs.Logger.Debugf("etcd keepAlive ok, key: %s, leaseid: [%x].", s.key, resp.ID)
 
 	return s.client.KeepAlive(ctx, resp.ID)
 }
 
+// UpdateLoad publish instance load with the register value,
+// error if Start has not been granted a lease yet, the load is registered by Start then.
+func (s *Agent) UpdateLoad(ctx context.Context, load LoadInfo) (err error) {
+	s.valueMu.Lock()
+	s.value.Load = &load
+	value, _ := json.Marshal(s.value)
+	leaseid := s.leaseid
+	s.valueMu.Unlock()
+	if leaseid == 0 {
+		// a put without lease would never expire
+		err = fmt.Errorf("UpdateLoad, error: no lease granted")
+		return
+	}
+	_, err = s.client.Put(ctx, s.key, string(value), clientv3.WithLease(leaseid))
+	if err != nil {
+		err = fmt.Errorf("s.client.Put(ctx, key, value, %x), %s", leaseid, err)
+	}
+	return
+}
+
 // revoke .
 func (s *Agent) revoke(ctx context.Context) error {
-	_, err := s.client.Revoke(ctx, s.leaseid)
+	s.valueMu.Lock()
+	leaseid := s.leaseid
+	s.leaseid = 0
+	s.valueMu.Unlock()
+	_, err := s.client.Revoke(ctx, leaseid)
 	if err != nil {
-		return err
+		return fmt.Errorf("s.client.Revoke(ctx, %x), %s", leaseid, err)
 	}
 	return s.client.Close()
 }